@@ -0,0 +1,149 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diagnostics extracts structured diagnostics from the output of
+// compilers, linters and test tools.
+//
+// Supported formats:
+//
+//	main.go:10:2: undefined: x                    (go build, go vet, go test)
+//	vet: main.go:10:2: unreachable code           (go vet)
+//	main.c:10:5: error: expected ';'              (gcc, clang)
+//	src/a.ts(10,5): error TS2322: message         (tsc)
+//	src/a.ts:10:5 - error TS2322: message         (tsc --pretty)
+package diagnostics
+
+import (
+	"bufio"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severities
+const (
+	Error   = "error"
+	Warning = "warning"
+	Info    = "info"
+)
+
+// Diagnostic is a problem reported by a tool at a position in a file.
+type Diagnostic struct {
+	// File is relative to the working directory when the file is inside
+	// of it.
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var (
+	tscParens = regexp.MustCompile(`^([^\s:()]+)\((\d+),(\d+)\): (error|warning) (TS\d+: .*)$`)
+	tscPretty = regexp.MustCompile(`^([^\s:()]+):(\d+):(\d+) - (error|warning) (TS\d+: .*)$`)
+	generic   = regexp.MustCompile(`^(?:vet: )?([^\s:()]+):(\d+)(?::(\d+))?: (?:(fatal error|error|warning|note): )?(.+)$`)
+)
+
+// Parse scans the output of a tool and extracts the diagnostics in it. File
+// paths are made relative to workDir.
+func Parse(output, workDir string) []Diagnostic {
+	var (
+		diags []Diagnostic
+		seen  = make(map[Diagnostic]bool)
+	)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 65536), 2*1048576)
+	for scanner.Scan() {
+		d, ok := parseLine(strings.TrimSpace(scanner.Text()))
+		if !ok {
+			continue
+		}
+		d.File = relativePath(d.File, workDir)
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+func parseLine(line string) (Diagnostic, bool) {
+	for _, re := range []*regexp.Regexp{tscParens, tscPretty} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			return Diagnostic{
+				File:     m[1],
+				Line:     atoi(m[2]),
+				Column:   atoi(m[3]),
+				Severity: m[4],
+				Message:  m[5],
+			}, true
+		}
+	}
+	m := generic.FindStringSubmatch(line)
+	if m == nil {
+		return Diagnostic{}, false
+	}
+	severity := Error
+	switch m[4] {
+	case "warning":
+		severity = Warning
+	case "note":
+		severity = Info
+	}
+	return Diagnostic{
+		File:     m[1],
+		Line:     atoi(m[2]),
+		Column:   atoi(m[3]),
+		Severity: severity,
+		Message:  m[5],
+	}, true
+}
+
+func relativePath(file, workDir string) string {
+	if !filepath.IsAbs(file) {
+		return filepath.Clean(file)
+	}
+	rel, err := filepath.Rel(workDir, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+	return rel
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// EditorURL expands the placeholders of an editor URL template for the
+// diagnostic: {path} is the absolute path of the file, {file} its path
+// relative to the working directory, {line} and {col} its position.
+func EditorURL(tpl string, d Diagnostic, workDir string) string {
+	if tpl == "" {
+		return ""
+	}
+	path := d.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir, path)
+	}
+	col := max(d.Column, 1)
+	return strings.NewReplacer(
+		"{path}", path,
+		"{file}", d.File,
+		"{line}", strconv.Itoa(d.Line),
+		"{col}", strconv.Itoa(col),
+	).Replace(tpl)
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	const output = `# example.com/app
+./main.go:10:2: undefined: x
+./main.go:10:2: undefined: x
+/work/app/internal/db/db.go:3:8: "fmt" imported and not used
+vet: internal/api/api.go:42:3: unreachable code
+/usr/lib/go/src/fmt/print.go:12: some message
+main.c:10:5: error: expected ';' before '}' token
+main.c:3:1: warning: return type defaults to 'int'
+main.c:4:1: note: declared here
+src/app.ts(10,5): error TS2322: Type 'string' is not assignable to type 'number'.
+src/app.ts:11:7 - warning TS6133: 'y' is declared but its value is never read.
+exec error build: (go build ./...) exit status 1
+2024/01/02 10:00:00 listening on :8080
+FAIL	example.com/app	0.002s`
+	got := Parse(output, "/work/app")
+	expected := []Diagnostic{
+		{File: "main.go", Line: 10, Column: 2, Severity: Error, Message: "undefined: x"},
+		{File: "internal/db/db.go", Line: 3, Column: 8, Severity: Error, Message: `"fmt" imported and not used`},
+		{File: "internal/api/api.go", Line: 42, Column: 3, Severity: Error, Message: "unreachable code"},
+		{File: "/usr/lib/go/src/fmt/print.go", Line: 12, Severity: Error, Message: "some message"},
+		{File: "main.c", Line: 10, Column: 5, Severity: Error, Message: "expected ';' before '}' token"},
+		{File: "main.c", Line: 3, Column: 1, Severity: Warning, Message: "return type defaults to 'int'"},
+		{File: "main.c", Line: 4, Column: 1, Severity: Info, Message: "declared here"},
+		{File: "src/app.ts", Line: 10, Column: 5, Severity: Error, Message: "TS2322: Type 'string' is not assignable to type 'number'."},
+		{File: "src/app.ts", Line: 11, Column: 7, Severity: Warning, Message: "TS6133: 'y' is declared but its value is never read."},
+	}
+	if !cmp.Equal(got, expected) {
+		t.Errorf("unexpected diagnostics:\n%v", cmp.Diff(expected, got))
+	}
+}
+
+func TestEditorURL(t *testing.T) {
+	d := Diagnostic{File: "internal/db/db.go", Line: 3}
+	got := EditorURL("vscode://file{path}:{line}:{col}?rel={file}", d, "/work/app")
+	const expected = "vscode://file/work/app/internal/db/db.go:3:1?rel=internal/db/db.go"
+	if got != expected {
+		t.Errorf("EditorURL() = %v, want %v", got, expected)
+	}
+	if got := EditorURL("", d, "/work/app"); got != "" {
+		t.Errorf("empty templates must not yield links, got: %v", got)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"cirello.io/runner/v3/internal/shell"
+)
+
+// Position is a location in a Procfile. Lines and columns start at 1.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	s := p.File
+	if s == "" {
+		s = "-"
+	}
+	if p.Line > 0 {
+		s += fmt.Sprintf(":%d", p.Line)
+		if p.Column > 0 {
+			s += fmt.Sprintf(":%d", p.Column)
+		}
+	}
+	return s
+}
+
+// ErrMalformedLine is the cause of the errors reported for lines that are not
+// comments and have no colon separating a name from its value.
+var ErrMalformedLine = errors.New(`malformed line, expected "name: command"`)
+
+// Error is a problem found at a position of a Procfile.
+type Error struct {
+	Pos Position
+	Err error
+
+	// IncludedFrom lists the include entries through which the file of
+	// Pos was read, from the innermost to the outermost one.
+	IncludedFrom []Position
+}
+
+func (e *Error) Error() string {
+	s := e.Pos.String() + ": " + e.Err.Error()
+	if len(e.IncludedFrom) > 0 {
+		from := make([]string, len(e.IncludedFrom))
+		for i, pos := range e.IncludedFrom {
+			from[i] = pos.String()
+		}
+		s += " (included from " + strings.Join(from, ", ") + ")"
+	}
+	return s
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is the list of problems found while parsing a Procfile.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// File is the syntax tree of a Procfile, with its nodes in order of
+// appearance. Included files are not expanded.
+type File struct {
+	Name  string
+	Nodes []Node
+}
+
+// Node is an element of the syntax tree: *Blank, *Comment, *Directive,
+// *Include, *Process or *BadLine.
+type Node interface {
+	Position() Position
+}
+
+// Blank is an empty line.
+type Blank struct {
+	Pos Position
+}
+
+// Comment is a line starting with # or //. Text includes the marker.
+type Comment struct {
+	Pos  Position
+	Text string
+}
+
+// Directive is a special entry that configures the runner, like workdir,
+// observe, ignore, formation, skip, proxy or remove.
+type Directive struct {
+	Pos   Position
+	Name  string
+	Value string
+}
+
+// Include is an entry that reads the process types and directives of another
+// Procfile.
+type Include struct {
+	Pos      Position
+	Path     string
+	Optional bool
+}
+
+// Process is the declaration of a process type, with the runner options
+// separated from its command.
+type Process struct {
+	Pos     Position
+	Name    string
+	Options []*Option
+	Command string
+
+	// CommandPos is the position of the command. Commands can span
+	// several lines, either with line continuations, kept as written,
+	// or as an indented script (Block), with the indentation removed.
+	CommandPos Position
+	Block      bool
+}
+
+// Option is a key=value setting of a process type.
+type Option struct {
+	Pos   Position
+	Key   string
+	Value string
+}
+
+// BadLine is a line that could not be parsed.
+type BadLine struct {
+	Pos  Position
+	Text string
+}
+
+func (n *Blank) Position() Position     { return n.Pos }
+func (n *Comment) Position() Position   { return n.Pos }
+func (n *Directive) Position() Position { return n.Pos }
+func (n *Include) Position() Position   { return n.Pos }
+func (n *Process) Position() Position   { return n.Pos }
+func (n *BadLine) Position() Position   { return n.Pos }
+
+// Option finds the last value of an option of the process type.
+func (p *Process) Option(key string) (string, bool) {
+	for i := len(p.Options) - 1; i >= 0; i-- {
+		if p.Options[i].Key == key {
+			return p.Options[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// directives are the names of the special entries, in their canonical form.
+var directives = map[string]string{
+	"workdir":   "workdir",
+	"observe":   "observe",
+	"watch":     "observe",
+	"ignore":    "ignore",
+	"formation": "formation",
+	"skip":      "skip",
+	"proxy":     "proxy",
+	"remove":    "remove",
+}
+
+// lookupDirective finds the canonical name of the directive called name, and
+// its kind: the canonical name itself, or "profile" for profile.<name>
+// directives, whose profile name is kept as written.
+func lookupDirective(name string) (canonical, kind string, ok bool) {
+	if prefix, profile, found := strings.Cut(name, "."); found && strings.ToLower(prefix) == "profile" && profile != "" {
+		return "profile." + profile, "profile", true
+	}
+	canonical, ok = directives[strings.ToLower(name)]
+	return canonical, canonical, ok
+}
+
+// options are the keys of the runner options of process types.
+var options = map[string]bool{
+	"waitfor":     true,
+	"restart":     true,
+	"outputs":     true,
+	"consumes":    true,
+	"reload":      true,
+	"reload-on":   true,
+	"socket":      true,
+	"after":       true,
+	"timeout":     true,
+	"signal":      true,
+	"retries":     true,
+	"retry-delay": true,
+	"group":       true,
+	"env":         true,
+	"envfile":     true,
+	"dir":         true,
+}
+
+// ParseAST reads the syntax tree of a Procfile. Filename is used in
+// positions. Lines ending with a backslash continue on the next line. A process
+// type declared without a command takes the lines indented deeper than its
+// declaration that follow it as its script. Lines that cannot be parsed are
+// kept in the tree as *BadLine nodes and reported in an ErrorList, along with
+// the tree.
+func ParseAST(filename string, r io.Reader) (*File, error) {
+	f := &File{Name: filename}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return f, err
+	}
+	var errs ErrorList
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		raw := lines[i]
+		line := strings.TrimSpace(raw)
+		indent := indentation(raw)
+		pos := Position{File: filename, Line: lineNo, Column: indent + 1}
+		if line == "" {
+			f.Nodes = append(f.Nodes, &Blank{Pos: Position{File: filename, Line: lineNo}})
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			f.Nodes = append(f.Nodes, &Comment{Pos: pos, Text: line})
+			continue
+		}
+		for continues(raw) && i+1 < len(lines) {
+			i++
+			raw += "\n" + lines[i]
+		}
+		// loosen translation of the official regex:
+		// ^*([A-Za-z0-9_-]+):\s*(.+)$
+		name, value, found := strings.Cut(raw[indent:], ":")
+		if !found {
+			f.Nodes = append(f.Nodes, &BadLine{Pos: pos, Text: strings.TrimSpace(raw)})
+			errs = append(errs, &Error{Pos: pos, Err: ErrMalformedLine})
+			continue
+		}
+		valueOffset := indent + len(name) + 1 + indentation(value)
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if strings.ToLower(name) == "include" {
+			path, optional := strings.CutPrefix(joinContinuations(value), "optional=")
+			f.Nodes = append(f.Nodes, &Include{Pos: pos, Path: path, Optional: optional})
+			continue
+		}
+		if _, _, ok := lookupDirective(name); ok {
+			f.Nodes = append(f.Nodes, &Directive{Pos: pos, Name: name, Value: joinContinuations(value)})
+			continue
+		}
+		p := parseProcess(pos, name, value, func(offset int) Position {
+			return positionAt(filename, lineNo, raw, valueOffset+offset)
+		})
+		if p.Command == "" {
+			i = parseBlock(p, lines, i, indent)
+		}
+		f.Nodes = append(f.Nodes, p)
+	}
+	if len(errs) > 0 {
+		return f, errs
+	}
+	return f, nil
+}
+
+// parseBlock sets the script that follows the declaration of p, at line index
+// i, as its command. The script is made of the lines indented deeper than the
+// declaration, with their common indentation removed. It returns the index of
+// the last line of the script.
+func parseBlock(p *Process, lines []string, i, indent int) int {
+	first, last := -1, i
+	for j := i + 1; j < len(lines); j++ {
+		if strings.TrimSpace(lines[j]) == "" {
+			continue
+		}
+		if indentation(lines[j]) <= indent {
+			break
+		}
+		if first < 0 {
+			first = j
+		}
+		last = j
+	}
+	if first < 0 {
+		return i
+	}
+	prefix := lines[first][:indentation(lines[first])]
+	for _, line := range lines[first : last+1] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for !strings.HasPrefix(line, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	script := make([]string, 0, last-first+1)
+	for _, line := range lines[first : last+1] {
+		script = append(script, strings.TrimRight(strings.TrimPrefix(line, prefix), " \t"))
+	}
+	p.Block = true
+	p.Command = strings.Join(script, "\n")
+	p.CommandPos = Position{File: p.Pos.File, Line: first + 1, Column: len(prefix) + 1}
+	return last
+}
+
+// indentation is the length of the leading blanks of s.
+func indentation(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " \t"))
+}
+
+// continues tells whether the line ends with an unescaped backslash.
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// joinContinuations replaces the line continuations of s with a space.
+func joinContinuations(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if i < len(lines)-1 {
+			line = strings.TrimSpace(strings.TrimSuffix(line, `\`))
+		}
+		lines[i] = line
+	}
+	return strings.Join(slices.DeleteFunc(lines, func(s string) bool { return s == "" }), " ")
+}
+
+// positionAt maps an offset of text, which starts at line, to its position.
+func positionAt(filename string, line int, text string, offset int) Position {
+	before := text[:offset]
+	return Position{
+		File:   filename,
+		Line:   line + strings.Count(before, "\n"),
+		Column: offset - strings.LastIndexByte(before, '\n'),
+	}
+}
+
+// parseProcess separates the runner options from the command of a process
+// type. Options are the leading key=value words with a known key, and the
+// words after a trailing -- separator when all of them are options. The
+// command is kept as written. At maps offsets of value to their position.
+func parseProcess(pos Position, name, value string, at func(int) Position) *Process {
+	p := &Process{Pos: pos, Name: name}
+	words := shell.Fields(value)
+	option := func(w shell.Word) *Option {
+		key, v, _ := strings.Cut(w.Text, "=")
+		return &Option{Pos: at(w.Start), Key: key, Value: v}
+	}
+	isOption := func(w shell.Word) bool {
+		key, _, found := strings.Cut(w.Raw, "=")
+		return found && options[key]
+	}
+	first := 0
+	for first < len(words) && isOption(words[first]) {
+		p.Options = append(p.Options, option(words[first]))
+		first++
+	}
+	last := len(words)
+	for i := len(words) - 1; i > first; i-- {
+		if words[i].Raw == "--" {
+			if i < len(words)-1 && !slices.ContainsFunc(words[i+1:], func(w shell.Word) bool { return !isOption(w) }) {
+				for _, w := range words[i+1:] {
+					p.Options = append(p.Options, option(w))
+				}
+				last = i
+			}
+			break
+		}
+	}
+	if first < last {
+		p.Command = value[words[first].Start:words[last-1].End]
+		p.CommandPos = at(words[first].Start)
+	}
+	return p
+}
+
+// commandPosition maps an offset of the command to its position.
+func (p *Process) commandPosition(offset int) Position {
+	before := p.Command[:offset]
+	nl := strings.LastIndexByte(before, '\n')
+	if nl < 0 {
+		pos := p.CommandPos
+		pos.Column += offset
+		return pos
+	}
+	pos := Position{
+		File:   p.CommandPos.File,
+		Line:   p.CommandPos.Line + strings.Count(before, "\n"),
+		Column: offset - nl,
+	}
+	if p.Block {
+		pos.Column += p.CommandPos.Column - 1
+	}
+	return pos
+}
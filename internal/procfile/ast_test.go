@@ -0,0 +1,183 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAST(t *testing.T) {
+	const example = `# services
+workdir: /src
+
+build: outputs=bin/server go build ./...
+  web:  restart=fail ./bin/server -- waitfor=localhost:5432
+include: optional=Procfile.local
+malformed-line
+`
+	f, err := ParseAST("Procfile", strings.NewReader(example))
+	var list ErrorList
+	if !errors.As(err, &list) || len(list) != 1 || !errors.Is(list[0], ErrMalformedLine) {
+		t.Fatalf("malformed lines must be reported as typed errors, got %v", err)
+	}
+	if got := list[0].Error(); got != `Procfile:7:1: malformed line, expected "name: command"` {
+		t.Errorf("unexpected error message: %s", got)
+	}
+	pos := func(line, col int) Position {
+		return Position{File: "Procfile", Line: line, Column: col}
+	}
+	expected := []Node{
+		&Comment{Pos: pos(1, 1), Text: "# services"},
+		&Directive{Pos: pos(2, 1), Name: "workdir", Value: "/src"},
+		&Blank{Pos: Position{File: "Procfile", Line: 3}},
+		&Process{Pos: pos(4, 1), Name: "build", Command: "go build ./...", CommandPos: pos(4, 27), Options: []*Option{
+			{Pos: pos(4, 8), Key: "outputs", Value: "bin/server"},
+		}},
+		&Process{Pos: pos(5, 3), Name: "web", Command: "./bin/server", CommandPos: pos(5, 22), Options: []*Option{
+			{Pos: pos(5, 9), Key: "restart", Value: "fail"},
+			{Pos: pos(5, 38), Key: "waitfor", Value: "localhost:5432"},
+		}},
+		&Include{Pos: pos(6, 1), Path: "Procfile.local", Optional: true},
+		&BadLine{Pos: pos(7, 1), Text: "malformed-line"},
+	}
+	if !cmp.Equal(f.Nodes, expected) {
+		t.Errorf("unexpected syntax tree:\n%v", cmp.Diff(expected, f.Nodes))
+	}
+}
+
+func TestParseASTMultiline(t *testing.T) {
+	const example = `build: timeout=1m \
+    go build \
+      -o bin/server ./cmd/server
+observe: *.go \
+  *.js
+web: restart=fail
+    # wait for the database
+    ./wait-for-db
+
+    exec ./bin/server \
+      --port $PORT
+worker: ./worker
+`
+	f, err := ParseAST("Procfile", strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := func(line, col int) Position {
+		return Position{File: "Procfile", Line: line, Column: col}
+	}
+	expected := []Node{
+		&Process{
+			Pos:        pos(1, 1),
+			Name:       "build",
+			Options:    []*Option{{Pos: pos(1, 8), Key: "timeout", Value: "1m"}},
+			Command:    "go build \\\n      -o bin/server ./cmd/server",
+			CommandPos: pos(2, 5),
+		},
+		&Directive{Pos: pos(4, 1), Name: "observe", Value: "*.go *.js"},
+		&Process{
+			Pos:        pos(6, 1),
+			Name:       "web",
+			Options:    []*Option{{Pos: pos(6, 6), Key: "restart", Value: "fail"}},
+			Command:    "# wait for the database\n./wait-for-db\n\nexec ./bin/server \\\n  --port $PORT",
+			CommandPos: pos(7, 5),
+			Block:      true,
+		},
+		&Process{Pos: pos(12, 1), Name: "worker", Command: "./worker", CommandPos: pos(12, 9)},
+	}
+	if !cmp.Equal(f.Nodes, expected) {
+		t.Errorf("unexpected syntax tree:\n%v", cmp.Diff(expected, f.Nodes))
+	}
+	web := f.Nodes[2].(*Process)
+	if got := web.commandPosition(strings.Index(web.Command, "--port")); got != pos(11, 7) {
+		t.Errorf("command positions must map back to the original lines, got %v", got)
+	}
+	build := f.Nodes[0].(*Process)
+	if got := build.commandPosition(strings.Index(build.Command, "-o")); got != pos(3, 7) {
+		t.Errorf("command positions must map back to the original lines, got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := Print(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	const printed = `build: timeout=1m go build \
+      -o bin/server ./cmd/server
+observe: *.go *.js
+web: restart=fail
+  # wait for the database
+  ./wait-for-db
+
+  exec ./bin/server \
+    --port $PORT
+worker: ./worker
+`
+	if got := buf.String(); got != printed {
+		t.Errorf("unexpected output:\n%s", cmp.Diff(printed, got))
+	}
+}
+
+func TestPrint(t *testing.T) {
+	const canonical = `# services
+workdir: /src
+observe: *.go
+
+build: outputs=bin/server go build ./...
+web: restart=fail waitfor=localhost:5432 ./bin/server --port $PORT
+include: optional=Procfile.local
+// legacy comment
+malformed-line
+`
+	f, _ := ParseAST("Procfile", strings.NewReader(canonical))
+	var buf bytes.Buffer
+	if err := Print(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != canonical {
+		t.Errorf("printer must round-trip canonical Procfiles:\n%s", cmp.Diff(canonical, got))
+	}
+
+	f, _ = ParseAST("Procfile", strings.NewReader("web:   ./server -- restart=fail\n"))
+	buf.Reset()
+	if err := Print(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "web: restart=fail ./server\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(fn, []byte("web: ./server\ninclude: "+filepath.Join(dir, "missing")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load(fn)
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("include errors must be typed, got %v", err)
+	}
+	if perr.Pos.File != fn || perr.Pos.Line != 2 || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
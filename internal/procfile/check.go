@@ -0,0 +1,365 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cirello.io/runner/v3/internal/runner"
+	"cirello.io/runner/v3/internal/shell"
+)
+
+// Severity of a problem found in a Procfile.
+type Severity string
+
+// Severity levels
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is an issue found in a Procfile by Check.
+type Problem struct {
+	Pos      Position
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Pos, p.Severity, p.Message)
+}
+
+// HasErrors tells whether any of the problems is an error.
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// buildOnlyOptions are the options that only apply to build process types.
+var buildOnlyOptions = map[string]bool{
+	"outputs":     true,
+	"after":       true,
+	"retries":     true,
+	"retry-delay": true,
+}
+
+// optionLike matches tokens that look like misspelled options, as opposed to
+// environment variable assignments (FOO=bar) or command flags (--foo=bar).
+var optionLike = regexp.MustCompile(`^[a-z][a-z0-9_-]*=`)
+
+type checker struct {
+	problems   []Problem
+	processes  []*Process
+	directives []*Directive
+	lookup     func(string) (string, bool)
+
+	// procs are the process types of the layers checked so far, checked
+	// the nodes that declare or patch them, and groups the groups they
+	// belong to.
+	procs   map[string]*Process
+	checked []*Process
+	groups  map[string]bool
+}
+
+func (c *checker) report(pos Position, severity Severity, format string, args ...any) {
+	c.problems = append(c.problems, Problem{
+		Pos:      pos,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Check lints the Procfile named fn, its overlays and the files they include,
+// and reports the problems that Parse silently ignores: malformed lines,
+// unknown restart modes and options, invalid option values, duplicated process
+// types, references to process types that do not exist, and undefined
+// variables. Each overlay is checked against the layers before it, see
+// Resolve. Env lists the KEY=VALUE variables available besides the
+// environment of the runner, see Config.Env.
+func Check(fn string, env []string, overlays ...string) ([]Problem, error) {
+	c := &checker{
+		lookup: lookupEnv(env),
+		procs:  make(map[string]*Process),
+		groups: make(map[string]bool),
+	}
+	for i, layer := range slices.Concat([]string{fn}, overlays) {
+		c.processes, c.directives = nil, nil
+		if err := c.readFile(layer, newIncludeChain(layer)); err != nil {
+			return nil, err
+		}
+		c.checkNodes(i > 0)
+	}
+	for _, p := range c.checked {
+		if _, ok := c.procs[p.Name]; ok {
+			c.checkAfter(p, c.procs)
+		}
+	}
+	slices.SortStableFunc(c.problems, func(a, b Problem) int {
+		return cmp.Or(
+			strings.Compare(a.Pos.File, b.Pos.File),
+			cmp.Compare(a.Pos.Line, b.Pos.Line),
+			cmp.Compare(a.Pos.Column, b.Pos.Column),
+		)
+	})
+	return c.problems, nil
+}
+
+func (c *checker) readFile(fn string, chain includeChain) error {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	f, err := ParseAST(fn, fd)
+	fd.Close()
+	if err := lenient(err); err != nil {
+		return err
+	}
+	for _, n := range f.Nodes {
+		switch n := n.(type) {
+		case *Process:
+			c.processes = append(c.processes, n)
+		case *Directive:
+			c.directives = append(c.directives, n)
+		case *BadLine:
+			c.report(n.Pos, SeverityError, "malformed line %q, expected \"name: command\"", n.Text)
+		case *Include:
+			files, err := resolveInclude(fn, n)
+			if err != nil {
+				c.report(n.Pos, SeverityError, "cannot include %q: %v", n.Path, err)
+				continue
+			}
+			for _, included := range files {
+				if err := chain.cycle(included); err != nil {
+					c.report(n.Pos, SeverityError, "%v", err)
+				} else if err := c.readFile(included, chain.push(included, n.Pos)); err != nil {
+					c.report(n.Pos, SeverityError, "cannot include %q: %v", n.Path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expand interpolates the variable references of a directive or option
+// value, and reports undefined variables. Prefix is prepended to the
+// messages.
+func (c *checker) expand(pos Position, prefix, s string) string {
+	v, undefined, err := interpolate(s, c.lookup)
+	if err != nil {
+		c.report(pos, SeverityError, "%s%v", prefix, err)
+		return s
+	}
+	for _, name := range undefined {
+		c.report(pos, SeverityWarning, "%sundefined variable %q, use ${%s:-} if it may be empty", prefix, name, name)
+	}
+	return v
+}
+
+// checkNodes checks the nodes of a layer. In overlays, process types already
+// declared are patched rather than redeclared.
+func (c *checker) checkNodes(overlay bool) {
+	procs := c.procs
+	for _, p := range c.processes {
+		prev, patch := procs[p.Name]
+		if patch && !overlay {
+			c.report(p.Pos, SeverityError, "process type %q already declared at %s", p.Name, prev.Pos)
+			continue
+		}
+		procs[p.Name] = p
+		c.checked = append(c.checked, p)
+		c.checkProcess(p, overlay && patch)
+		if v, ok := p.Option("group"); ok {
+			for _, g := range parseList(v) {
+				c.groups[g] = true
+			}
+		}
+	}
+	groups := c.groups
+	for _, d := range c.directives {
+		value := c.expand(d.Pos, "", d.Value)
+		canonical, kind, _ := lookupDirective(d.Name)
+		switch kind {
+		case "workdir", "observe", "ignore", "skip":
+			if value == "" {
+				c.report(d.Pos, SeverityWarning, "empty %s directive", d.Name)
+			}
+		case "formation":
+			c.checkFormation(d, "formation", value, procs, nil)
+		case "profile":
+			if value == "" {
+				c.report(d.Pos, SeverityWarning, "empty %s directive", canonical)
+			}
+			c.checkFormation(d, canonical, value, procs, groups)
+		case "proxy":
+			c.checkProxy(d, value, procs)
+		case "remove":
+			if !overlay {
+				c.report(d.Pos, SeverityWarning, "remove only applies to overlays, like Procfile.local")
+				continue
+			}
+			for _, name := range strings.Fields(value) {
+				if _, ok := procs[name]; !ok {
+					c.report(d.Pos, SeverityError, "cannot remove unknown process type %q", name)
+				}
+				delete(procs, name)
+			}
+		}
+	}
+}
+
+// checkFormation checks the entries of a formation, or of a profile when
+// groups, the groups declared by process types, is not nil.
+func (c *checker) checkFormation(d *Directive, what, value string, procs map[string]*Process, groups map[string]bool) {
+	for _, entry := range strings.Fields(value) {
+		name, count, hasCount := strings.Cut(entry, ":")
+		if group, ok := strings.CutPrefix(name, "@"); ok && groups != nil {
+			if !groups[group] {
+				c.report(d.Pos, SeverityWarning, "%s for unknown group %q", what, group)
+			}
+		} else if _, ok := procs[name]; !ok {
+			c.report(d.Pos, SeverityWarning, "%s for unknown process type %q", what, name)
+		}
+		if _, err := strconv.Atoi(count); hasCount && err != nil {
+			c.report(d.Pos, SeverityError, "invalid %s count %q for %q", what, count, name)
+		}
+	}
+}
+
+func (c *checker) checkProxy(d *Directive, value string, procs map[string]*Process) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		c.report(d.Pos, SeverityError, "proxy without listen address")
+		return
+	}
+	for _, field := range fields[1:] {
+		k, v, found := strings.Cut(field, "=")
+		switch {
+		case !found:
+			c.report(d.Pos, SeverityError, "invalid proxy route %q, expected [host][/path]=procType", field)
+		case k == "hold":
+			if _, err := time.ParseDuration(v); err != nil {
+				c.report(d.Pos, SeverityError, "invalid proxy hold %q: %v", v, err)
+			}
+		default:
+			if _, ok := procs[v]; !ok {
+				c.report(d.Pos, SeverityWarning, "proxy route %q to unknown process type %q", k, v)
+			}
+		}
+	}
+}
+
+// checkProcess checks a process type declaration, or when patch is set, an
+// overlay entry that patches a process type, which may omit the command.
+func (c *checker) checkProcess(p *Process, patch bool) {
+	isBuild := strings.HasPrefix(p.Name, "build")
+	report := func(pos Position, severity Severity, format string, args ...any) {
+		c.report(pos, severity, p.Name+": "+format, args...)
+	}
+	if p.Command == "" {
+		if !patch {
+			report(p.Pos, SeverityError, "empty command")
+		}
+	} else if first, _, _ := strings.Cut(p.Command, " "); optionLike.MatchString(first) {
+		key, _, _ := strings.Cut(first, "=")
+		report(p.CommandPos, SeverityWarning, "unknown option %q becomes part of the command", key)
+	}
+	for _, w := range shell.Fields(p.Command) {
+		if key, _, found := strings.Cut(w.Raw, "="); found && options[key] && w.Start > 0 {
+			report(p.commandPosition(w.Start), SeverityWarning, "option %q after the command is passed to it, move it before the command or after a trailing --", key)
+		}
+	}
+	for _, o := range p.Options {
+		value := c.expand(o.Pos, p.Name+": ", o.Value)
+		if buildOnlyOptions[o.Key] && !isBuild {
+			report(o.Pos, SeverityWarning, "option %q only applies to build process types", o.Key)
+		}
+		switch o.Key {
+		case "restart":
+			if _, ok := runner.LookupRestartMode(value); !ok {
+				report(o.Pos, SeverityError, "unknown restart mode %q", value)
+			} else if isBuild {
+				report(o.Pos, SeverityWarning, "option %q does not apply to build process types", o.Key)
+			}
+		case "waitfor":
+			if value == "" {
+				report(o.Pos, SeverityError, "empty waitfor target")
+			}
+		case "reload":
+			if runner.ParseSignal(value) == 0 {
+				report(o.Pos, SeverityError, "unknown reload signal %q", value)
+			}
+		case "signal":
+			if runner.ParseSignal(value) == 0 {
+				report(o.Pos, SeverityError, "unknown signal %q", value)
+			} else if isBuild {
+				report(o.Pos, SeverityWarning, "option %q does not apply to build process types", o.Key)
+			}
+		case "socket":
+			if network, _, _ := strings.Cut(value, ":"); network != "tcp" {
+				report(o.Pos, SeverityError, "unsupported socket type %q", network)
+			}
+		case "timeout", "retry-delay":
+			if _, err := time.ParseDuration(value); err != nil {
+				report(o.Pos, SeverityError, "invalid %s %q: %v", o.Key, value, err)
+			}
+		case "env":
+			if k, _, found := strings.Cut(value, "="); !found || !isEnvName(k) {
+				report(o.Pos, SeverityError, "invalid env %q, expected KEY=VALUE", value)
+			}
+		case "envfile":
+			if len(parseList(value)) == 0 {
+				report(o.Pos, SeverityWarning, "empty envfile list")
+			}
+		case "dir":
+			if value == "" {
+				report(o.Pos, SeverityError, "empty dir")
+			}
+		case "group":
+			if len(parseList(value)) == 0 {
+				report(o.Pos, SeverityWarning, "empty group list")
+			}
+		case "retries":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				report(o.Pos, SeverityError, "invalid retries %q, expected a non-negative number", value)
+			}
+		}
+	}
+}
+
+func (c *checker) checkAfter(p *Process, procs map[string]*Process) {
+	for _, o := range p.Options {
+		if o.Key != "after" {
+			continue
+		}
+		for _, dep := range parseList(o.Value) {
+			if dep == p.Name {
+				c.report(o.Pos, SeverityError, "%s: build step cannot run after itself", p.Name)
+			} else if _, ok := procs[dep]; !ok || !strings.HasPrefix(dep, "build") {
+				c.report(o.Pos, SeverityError, "%s: runs after unknown build step %q", p.Name, dep)
+			}
+		}
+	}
+}
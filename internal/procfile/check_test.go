@@ -0,0 +1,117 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	extra := write("extra.Procfile", "worker: ./worker restart=fail\nbad line\n")
+	main := write("Procfile", `# comment
+build: go build ./...
+build-vet: after=build-codegen go vet ./...
+web: restart=onfailur waitfor=localhost:8888 ./server
+web: ./other
+api: wait=localhost:1 FOO=bar ./api --flag=value
+formation: web:2 ghost:1
+malformed-line
+include: `+extra+`
+include: optional=missing.Procfile
+multi: \
+  restart=nevr ./server \
+  restart=loop
+profile.lite: @frontend:1 @nope:1 api:x
+web-ui: group=frontend env=1X=y dir= ./ui
+worker-b: signal=TERMINATE ./worker
+`)
+	problems, err := Check(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, strings.TrimPrefix(p.String(), dir+string(filepath.Separator)))
+	}
+	expected := []string{
+		`Procfile:3:12: error: build-vet: runs after unknown build step "build-codegen"`,
+		`Procfile:4:6: error: web: unknown restart mode "onfailur"`,
+		`Procfile:5:1: error: process type "web" already declared at ` + main + `:4:1`,
+		`Procfile:6:6: warning: api: unknown option "wait" becomes part of the command`,
+		`Procfile:7:1: warning: formation for unknown process type "ghost"`,
+		`Procfile:8:1: error: malformed line "malformed-line", expected "name: command"`,
+		`Procfile:12:3: error: multi: unknown restart mode "nevr"`,
+		`Procfile:13:3: warning: multi: option "restart" after the command is passed to it, move it before the command or after a trailing --`,
+		`Procfile:14:1: warning: profile.lite for unknown group "nope"`,
+		`Procfile:14:1: error: invalid profile.lite count "x" for "api"`,
+		`Procfile:15:24: error: web-ui: invalid env "1X=y", expected KEY=VALUE`,
+		`Procfile:15:33: error: web-ui: empty dir`,
+		`Procfile:16:11: error: worker-b: unknown signal "TERMINATE"`,
+		`extra.Procfile:1:18: warning: worker: option "restart" after the command is passed to it, move it before the command or after a trailing --`,
+		`extra.Procfile:2:1: error: malformed line "bad line", expected "name: command"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected problems:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if !HasErrors(problems) {
+		t.Error("errors must be reported")
+	}
+
+	clean := write("Clean.Procfile", "build: go build ./...\nweb: restart=fail ./server\nformation: web:2\n")
+	problems, err = Check(clean, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	local := write("Clean.Procfile.local", `web: restart=always
+web: ./server --debug
+remove: build ghost
+formation: web:3 nope:1
+api: restart=bogus ./api
+profile.dev: build:1
+`)
+	problems, err = Check(clean, nil, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, p := range problems {
+		got = append(got, strings.TrimPrefix(p.String(), dir+string(filepath.Separator)))
+	}
+	expected = []string{
+		`Clean.Procfile.local:3:1: error: cannot remove unknown process type "ghost"`,
+		`Clean.Procfile.local:4:1: warning: formation for unknown process type "nope"`,
+		`Clean.Procfile.local:5:6: error: api: unknown restart mode "bogus"`,
+		`Clean.Procfile.local:6:1: warning: profile.dev for unknown process type "build"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected overlay problems:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
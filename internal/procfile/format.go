@@ -0,0 +1,169 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Format rewrites the Procfile src in its canonical form:
+//
+//   - directives that precede the first include come first, under the
+//     leading comment block of the file, with their names in lower case and
+//     watch spelled as observe;
+//   - options are sorted by key and written before the command;
+//   - process type names are aligned within blocks separated by blank lines;
+//   - comments start with #, and runs of blank lines are collapsed.
+//
+// Comments stay attached to the entry that follows them. Includes, the
+// directives that follow them and remove directives are kept in place, as
+// their position decides which entries win and what they apply to. Files with
+// malformed lines are not formatted.
+func Format(filename string, src []byte) ([]byte, error) {
+	f, err := ParseAST(filename, bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	var header, head, body []Node
+	var pending []Node
+	inHeader, included := true, false
+	for _, n := range f.Nodes {
+		switch n := n.(type) {
+		case *Comment:
+			pending = append(pending, &Comment{Pos: n.Pos, Text: canonicalComment(n.Text)})
+			continue
+		case *Blank:
+			if inHeader && len(pending) > 0 {
+				header, pending = pending, nil
+				inHeader = false
+			}
+			body = append(body, pending...)
+			body = append(body, n)
+			pending = nil
+			continue
+		case *Directive:
+			name, kind, _ := lookupDirective(n.Name)
+			d := &Directive{Pos: n.Pos, Name: name, Value: n.Value}
+			if included || kind == "remove" {
+				body = append(body, pending...)
+				body = append(body, d)
+			} else {
+				head = append(head, pending...)
+				head = append(head, d)
+			}
+			pending = nil
+		case *Process:
+			p := &Process{Pos: n.Pos, Name: n.Name, Command: n.Command, CommandPos: n.CommandPos, Block: n.Block}
+			p.Options = slices.Clone(n.Options)
+			slices.SortStableFunc(p.Options, func(a, b *Option) int {
+				return cmp.Compare(a.Key, b.Key)
+			})
+			body = append(body, pending...)
+			body = append(body, p)
+			pending = nil
+		case *Include:
+			body = append(body, pending...)
+			body = append(body, n)
+			pending = nil
+			included = true
+		default:
+			body = append(body, pending...)
+			body = append(body, n)
+			pending = nil
+		}
+		inHeader = false
+	}
+	body = append(body, pending...)
+
+	var lines []Node
+	for _, section := range [][]Node{header, head, body} {
+		lines = append(lines, &Blank{})
+		lines = append(lines, section...)
+	}
+	lines = collapseBlanks(lines)
+
+	var buf bytes.Buffer
+	for block := range splitBlocks(lines) {
+		width := 0
+		for _, n := range block {
+			if p, ok := n.(*Process); ok {
+				width = max(width, len(p.Name))
+			}
+		}
+		for _, n := range block {
+			if p, ok := n.(*Process); ok && printProcessValue(p) != "" {
+				buf.WriteString(p.Name + ":" + strings.Repeat(" ", width-len(p.Name)+1))
+				buf.WriteString(printProcessValue(p))
+				buf.WriteString(printBlock(p))
+			} else {
+				buf.WriteString(printNode(n))
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalComment replaces the // comment marker with #.
+func canonicalComment(text string) string {
+	if rest, ok := strings.CutPrefix(text, "//"); ok {
+		return "#" + rest
+	}
+	return text
+}
+
+// collapseBlanks drops leading, trailing and repeated blank lines.
+func collapseBlanks(nodes []Node) []Node {
+	var out []Node
+	for _, n := range nodes {
+		if _, ok := n.(*Blank); ok {
+			if len(out) == 0 {
+				continue
+			}
+			if _, prevBlank := out[len(out)-1].(*Blank); prevBlank {
+				continue
+			}
+		}
+		out = append(out, n)
+	}
+	if len(out) > 0 {
+		if _, ok := out[len(out)-1].(*Blank); ok {
+			out = out[:len(out)-1]
+		}
+	}
+	return out
+}
+
+// splitBlocks yields the runs of nodes that end at a blank line, the blank
+// line included.
+func splitBlocks(nodes []Node) func(yield func([]Node) bool) {
+	return func(yield func([]Node) bool) {
+		start := 0
+		for i, n := range nodes {
+			if _, ok := n.(*Blank); ok {
+				if !yield(nodes[start : i+1]) {
+					return
+				}
+				start = i + 1
+			}
+		}
+		if start < len(nodes) {
+			yield(nodes[start:])
+		}
+	}
+}
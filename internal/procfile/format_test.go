@@ -0,0 +1,127 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cirello.io/runner/v3/internal/runner"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestFormat(t *testing.T) {
+	const src = `
+# my project
+// second header line
+
+web: ./server -- restart=fail   waitfor=localhost:5432
+WATCH: *.go
+// the build
+build-server: outputs=bin/server after=build-codegen go build ./...
+
+
+build-codegen: go generate ./...
+include: optional=Procfile.local
+workdir: /src
+// trailing
+`
+	const expected = `# my project
+# second header line
+
+observe: *.go
+
+web:          restart=fail waitfor=localhost:5432 ./server
+# the build
+build-server: after=build-codegen outputs=bin/server go build ./...
+
+build-codegen: go generate ./...
+include: optional=Procfile.local
+workdir: /src
+# trailing
+`
+	got, err := Format("Procfile", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected {
+		t.Errorf("unexpected formatting:\n%s", cmp.Diff(expected, string(got)))
+	}
+	again, err := Format("Procfile", got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("formatting must be idempotent:\n%s", cmp.Diff(string(got), string(again)))
+	}
+	const block = "web: restart=fail\n\t# wait for the database\n\t./wait-for-db\n\n\texec ./server\nworker: ./worker \\\n    --queue=jobs\n"
+	const blockExpected = "web:    restart=fail\n  # wait for the database\n  ./wait-for-db\n\n  exec ./server\nworker: ./worker \\\n    --queue=jobs\n"
+	got, err = Format("Procfile", []byte(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != blockExpected {
+		t.Errorf("unexpected formatting:\n%s", cmp.Diff(blockExpected, string(got)))
+	}
+	if _, err := Format("Procfile", []byte("web: ./server\nmalformed-line\n")); !errors.Is(err, ErrMalformedLine) {
+		t.Errorf("malformed Procfiles must not be formatted, got: %v", err)
+	}
+}
+
+func TestFormatKeepsMeaning(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.Procfile":  "workdir: ./base\nweb: ./base-server\nworker: ./worker\n",
+		"Procfile":       "web: ./a\nformation: web:2 worker:1\ninclude: base.Procfile\nworkdir: ./app\napi: ./api\n",
+		"Procfile.local": "web: ./local\nremove: web\nWATCH: *.go\nworker: restart=fail\n",
+	})
+	fn := filepath.Join(dir, "Procfile")
+	overlay := filepath.Join(dir, "Procfile.local")
+	resolve := func() *runner.Runner {
+		t.Helper()
+		c, err := Resolve(fn, overlay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rnr, err := c.Runner()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rnr
+	}
+	before := resolve()
+	for _, name := range []string{fn, overlay} {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := Format(name, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, formatted, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := resolve()
+	if diff := cmp.Diff(before, after, cmpopts.IgnoreUnexported(runner.Runner{})); diff != "" {
+		t.Errorf("formatting changed the configuration:\n%s", diff)
+	}
+	if before.WorkDir != "./app" {
+		t.Errorf("unexpected workdir: %s", before.WorkDir)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// resolveInclude lists the files an include entry of the file named parent
+// refers to. Relative paths are resolved from the directory of parent, and
+// glob patterns expand to the matching files in lexical order. Missing
+// optional files are skipped.
+func resolveInclude(parent string, n *Include) ([]string, error) {
+	path := n.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(parent), path)
+	}
+	if strings.ContainsAny(n.Path, "*?[") {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		return slices.DeleteFunc(matches, func(fn string) bool {
+			fi, err := os.Stat(fn)
+			return err != nil || fi.IsDir()
+		}), nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		if n.Optional && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// includeChain is the list of files being read, from the Procfile to the
+// innermost included file, along with the include entries that led to them.
+type includeChain struct {
+	files []string
+	keys  []string
+	from  []Position
+}
+
+func newIncludeChain(root string) includeChain {
+	return includeChain{files: []string{root}, keys: []string{includeKey(root)}}
+}
+
+// includeKey identifies a file regardless of how its path is written.
+func includeKey(fn string) string {
+	if fn == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(fn); err == nil {
+		return abs
+	}
+	return filepath.Clean(fn)
+}
+
+// push returns the chain extended with the file fn, included by the entry at
+// from.
+func (c includeChain) push(fn string, from Position) includeChain {
+	return includeChain{
+		files: append(slices.Clip(c.files), fn),
+		keys:  append(slices.Clip(c.keys), includeKey(fn)),
+		from:  append(slices.Clip(c.from), from),
+	}
+}
+
+// cycle reports whether including fn would read a file of the chain again.
+func (c includeChain) cycle(fn string) error {
+	i := slices.Index(c.keys, includeKey(fn))
+	if i < 0 {
+		return nil
+	}
+	return fmt.Errorf("include cycle: %s -> %s", strings.Join(c.files[i:], " -> "), fn)
+}
+
+// error locates err at pos, within the innermost file of the chain.
+func (c includeChain) error(pos Position, err error) *Error {
+	var includedFrom []Position
+	for i := len(c.from) - 1; i >= 0; i-- {
+		includedFrom = append(includedFrom, c.from[i])
+	}
+	return &Error{Pos: pos, Err: err, IncludedFrom: includedFrom}
+}
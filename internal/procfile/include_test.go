@@ -0,0 +1,101 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app/Procfile":                            "web: ./web\ninclude: procfiles.d/*.procfile\ninclude: nested/Procfile\ninclude: optional=Procfile.local\n",
+		"app/procfiles.d/b.procfile":              "b: ./b\n",
+		"app/procfiles.d/a.procfile":              "a: ./a\n",
+		"app/procfiles.d/notes.txt":               "not: included\n",
+		"app/nested/Procfile":                     "nested: ./nested\ninclude: ../../shared.procfile\n",
+		"shared.procfile":                         "shared: ./shared\n",
+		"app/procfiles.d/dir.procfile/c.procfile": "c: ./c\n",
+	})
+	got, err := Load(filepath.Join(dir, "app", "Procfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range got.Processes {
+		names = append(names, p.Name)
+	}
+	expected := []string{"web", "a", "b", "nested", "shared"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("includes must be resolved from the including file, in order:\n%v", cmp.Diff(expected, names))
+	}
+}
+
+func TestLoadIncludeErrors(t *testing.T) {
+	t.Run("cycle", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"Procfile":   "web: ./web\ninclude: a.procfile\n",
+			"a.procfile": "a: ./a\ninclude: sub/b.procfile\n",
+			"sub/b.procfile": "b: ./b\n" +
+				"include: ../a.procfile\n",
+		})
+		fn := filepath.Join(dir, "Procfile")
+		_, err := Load(fn)
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected a positioned error, got %v", err)
+		}
+		a, b := filepath.Join(dir, "a.procfile"), filepath.Join(dir, "sub", "b.procfile")
+		expected := b + ":2:1: include cycle: " + a + " -> " + b + " -> " + a +
+			" (included from " + a + ":2:1, " + fn + ":2:1)"
+		if got := err.Error(); got != expected {
+			t.Errorf("unexpected error:\n%s\nexpected:\n%s", got, expected)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"Procfile":   "include: a.procfile\n",
+			"a.procfile": "include: missing.procfile\n",
+		})
+		_, err := Load(filepath.Join(dir, "Procfile"))
+		var perr *Error
+		if !errors.As(err, &perr) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected a positioned error, got %v", err)
+		}
+		expected := []Position{{File: filepath.Join(dir, "Procfile"), Line: 1, Column: 1}}
+		if perr.Pos.File != filepath.Join(dir, "a.procfile") || !cmp.Equal(perr.IncludedFrom, expected) {
+			t.Errorf("errors must report the include chain, got %v", err)
+		}
+	})
+}
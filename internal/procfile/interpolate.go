@@ -0,0 +1,146 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"cirello.io/runner/v3/internal/shell"
+)
+
+// lookupEnv finds variables in env, a list of KEY=VALUE pairs where later
+// pairs take precedence, and then in the environment of the runner.
+func lookupEnv(env []string) func(string) (string, bool) {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	return func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// interpolate expands the variable references of s: $VAR and ${VAR} are
+// replaced by the value of VAR, or nothing when it is undefined;
+// ${VAR:-default} by default when VAR is undefined or empty; and
+// ${VAR:?message} fails with message when VAR is undefined or empty. $$ is a
+// literal dollar sign. It also returns the names of the undefined variables
+// that were replaced by nothing.
+func interpolate(s string, lookup func(string) (string, bool)) (string, []string, error) {
+	var sb strings.Builder
+	var undefined []string
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			sb.WriteByte('$')
+			i++
+		case next == '{':
+			end := shell.MatchingBrace(s, i+1)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated variable reference %q", s[i:])
+			}
+			v, undef, err := expandReference(s[i+2:end], lookup)
+			if err != nil {
+				return "", nil, err
+			}
+			sb.WriteString(v)
+			undefined = append(undefined, undef...)
+			i = end
+		case isNameStart(next):
+			end := i + 1
+			for end < len(s) && isNameChar(s[end]) {
+				end++
+			}
+			name := s[i+1 : end]
+			v, ok := lookup(name)
+			if !ok {
+				undefined = append(undefined, name)
+			}
+			sb.WriteString(v)
+			i = end - 1
+		default:
+			sb.WriteByte('$')
+		}
+	}
+	return sb.String(), undefined, nil
+}
+
+// expandReference expands the content of a ${...} reference.
+func expandReference(ref string, lookup func(string) (string, bool)) (string, []string, error) {
+	n := 0
+	for n < len(ref) && isNameChar(ref[n]) {
+		n++
+	}
+	name, rest := ref[:n], ref[n:]
+	if name == "" || !isNameStart(name[0]) {
+		return "", nil, fmt.Errorf("invalid variable reference ${%s}", ref)
+	}
+	v, ok := lookup(name)
+	switch {
+	case rest == "":
+		if !ok {
+			return "", []string{name}, nil
+		}
+		return v, nil, nil
+	case strings.HasPrefix(rest, ":-"):
+		if v != "" {
+			return v, nil, nil
+		}
+		return interpolate(rest[2:], lookup)
+	case strings.HasPrefix(rest, ":?"):
+		if v != "" {
+			return v, nil, nil
+		}
+		msg := rest[2:]
+		if msg == "" {
+			msg = "undefined or empty"
+		}
+		return "", nil, fmt.Errorf("%s: %s", name, msg)
+	default:
+		return "", nil, fmt.Errorf("invalid variable reference ${%s}", ref)
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || '0' <= c && c <= '9'
+}
+
+// isEnvName tells whether s is a valid environment variable name.
+func isEnvName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cirello.io/runner/v3/internal/runner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("RUNNER_TEST_HOST", "localhost")
+	t.Setenv("RUNNER_TEST_PORT", "from-environment")
+	t.Setenv("RUNNER_TEST_EMPTY", "")
+	lookup := lookupEnv([]string{"RUNNER_TEST_PORT=5432", "RUNNER_TEST_NAME=app"})
+	tests := []struct {
+		in        string
+		out       string
+		undefined []string
+		err       string
+	}{
+		{in: "plain", out: "plain"},
+		{in: "$RUNNER_TEST_HOST:${RUNNER_TEST_PORT}", out: "localhost:5432"},
+		{in: "${RUNNER_TEST_NAME}-db", out: "app-db"},
+		{in: "$RUNNER_TEST_NAME.sock", out: "app.sock"},
+		{in: "${RUNNER_TEST_MISSING:-8080}", out: "8080"},
+		{in: "${RUNNER_TEST_EMPTY:-8080}", out: "8080"},
+		{in: "${RUNNER_TEST_MISSING:-$RUNNER_TEST_HOST:${RUNNER_TEST_PORT}}", out: "localhost:5432"},
+		{in: "${RUNNER_TEST_MISSING:-}", out: ""},
+		{in: "${RUNNER_TEST_HOST:?host required}", out: "localhost"},
+		{in: "$RUNNER_TEST_MISSING/bin", out: "/bin", undefined: []string{"RUNNER_TEST_MISSING"}},
+		{in: "${RUNNER_TEST_EMPTY}", out: ""},
+		{in: "$$HOME $ 5$", out: "$HOME $ 5$"},
+		{in: "${RUNNER_TEST_MISSING:?set it in .env}", err: "RUNNER_TEST_MISSING: set it in .env"},
+		{in: "${RUNNER_TEST_EMPTY:?}", err: "RUNNER_TEST_EMPTY: undefined or empty"},
+		{in: "${RUNNER_TEST_HOST", err: `unterminated variable reference "${RUNNER_TEST_HOST"`},
+		{in: "${1abc}", err: "invalid variable reference ${1abc}"},
+		{in: "${RUNNER_TEST_HOST:+x}", err: "invalid variable reference ${RUNNER_TEST_HOST:+x}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			out, undefined, err := interpolate(tt.in, lookup)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.out || !cmp.Equal(undefined, tt.undefined) {
+				t.Errorf("got %q (undefined %v), expected %q (undefined %v)", out, undefined, tt.out, tt.undefined)
+			}
+		})
+	}
+}
+
+func TestResolveInterpolation(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"Procfile": `observe: *.${RUNNER_TEST_EXT:-go}
+web: waitfor=localhost:${RUNNER_TEST_DB_PORT} timeout=${RUNNER_TEST_TIMEOUT:-5s} ./server --port $PORT
+api: waitfor=${RUNNER_TEST_API:?api address required} ./api
+`,
+	})
+	c, err := Resolve(filepath.Join(dir, "Procfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Runner()
+	if err == nil || !strings.HasSuffix(err.Error(), "Procfile:3:6: RUNNER_TEST_API: api address required") {
+		t.Errorf("failed references must be reported with their position, got: %v", err)
+	}
+
+	c.Env = []string{"RUNNER_TEST_DB_PORT=5432", "RUNNER_TEST_API=localhost:9000"}
+	got, err := c.Runner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "web", Cmd: "./server --port $PORT", WaitFor: "localhost:5432", Timeout: 5e9},
+		{Name: "api", Cmd: "./api", WaitFor: "localhost:9000"},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("options must be interpolated, and commands left to the shell:\n%v", cmp.Diff(expected, got.Processes))
+	}
+	if expected := []string{"*.go"}; !cmp.Equal(got.Observables, expected) {
+		t.Errorf("directives must be interpolated, got: %v", got.Observables)
+	}
+
+	problems, err := Check(filepath.Join(dir, "Procfile"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, p := range problems {
+		messages = append(messages, strings.TrimPrefix(p.String(), dir+string(filepath.Separator)))
+	}
+	expectedProblems := []string{
+		`Procfile:2:6: warning: web: undefined variable "RUNNER_TEST_DB_PORT", use ${RUNNER_TEST_DB_PORT:-} if it may be empty`,
+		`Procfile:3:6: error: api: RUNNER_TEST_API: api address required`,
+	}
+	if !cmp.Equal(messages, expectedProblems) {
+		t.Errorf("unexpected problems:\n%v", cmp.Diff(expectedProblems, messages))
+	}
+	if problems, _ := Check(filepath.Join(dir, "Procfile"), c.Env); len(problems) != 0 {
+		t.Errorf("variables of the environment file must be defined, got: %v", problems)
+	}
+}
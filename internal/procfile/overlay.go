@@ -0,0 +1,238 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Config is the configuration resolved from a Procfile, the files it
+// includes and its overlays.
+type Config struct {
+	Entries []*Entry
+
+	// Env lists KEY=VALUE variables, like the ones of the .env file, used
+	// to expand the variable references of directives and options. They
+	// take precedence over the environment of the runner, as they do in
+	// the environment of the processes.
+	Env []string
+}
+
+// Entry is a directive or a process type of the resolved configuration.
+type Entry struct {
+	// Node is a *Directive or a *Process.
+	Node Node
+
+	// Origin is the position of the declaration of the entry, followed by
+	// the positions of the overlay entries that changed or removed it.
+	Origin []Position
+
+	// Removed tells whether an overlay removed the process type.
+	Removed bool
+}
+
+// Resolve reads the Procfile named fn and applies the overlays on top of it,
+// in order. In overlays:
+//
+//   - process types replace the command of the process type with the same
+//     name, when they have one, and the options they set; other process types
+//     are added;
+//   - remove: lists process types to drop;
+//   - formation: changes the number of instances of the process types it
+//     lists, and keeps the others;
+//   - skip: adds to the skipped process types, and the other directives,
+//     profiles included, replace the ones of the earlier layers with the same
+//     name.
+func Resolve(fn string, overlays ...string) (*Config, error) {
+	entries, err := readFile(fn, newIncludeChain(fn))
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{Entries: entries}
+	var formations []*Directive
+	for _, overlay := range overlays {
+		entries, err := readFile(overlay, newIncludeChain(overlay))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch n := e.Node.(type) {
+			case *Process:
+				c.patchProcess(e, n)
+			case *Directive:
+				switch _, kind, _ := lookupDirective(n.Name); kind {
+				case "remove":
+					if err := c.remove(n); err != nil {
+						return nil, err
+					}
+				case "formation":
+					formations = append(formations, n)
+				case "skip":
+					c.Entries = append(c.Entries, e)
+				default:
+					c.replaceDirective(e, n)
+				}
+			}
+		}
+	}
+	c.patchFormation(formations)
+	return c, nil
+}
+
+// process finds the last process type declared with the name, and not
+// removed.
+func (c *Config) process(name string) *Entry {
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		e := c.Entries[i]
+		if p, ok := e.Node.(*Process); ok && p.Name == name && !e.Removed {
+			return e
+		}
+	}
+	return nil
+}
+
+// directive finds the last directive with the canonical name.
+func (c *Config) directive(name string) *Entry {
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		if d, ok := c.Entries[i].Node.(*Directive); ok {
+			if canonical, _, _ := lookupDirective(d.Name); canonical == name {
+				return c.Entries[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) patchProcess(e *Entry, n *Process) {
+	base := c.process(n.Name)
+	if base == nil {
+		c.Entries = append(c.Entries, e)
+		return
+	}
+	prev := base.Node.(*Process)
+	p := &Process{
+		Pos:        prev.Pos,
+		Name:       prev.Name,
+		Command:    prev.Command,
+		CommandPos: prev.CommandPos,
+		Block:      prev.Block,
+	}
+	if n.Command != "" {
+		p.Command, p.CommandPos, p.Block = n.Command, n.CommandPos, n.Block
+	}
+	for _, o := range prev.Options {
+		if _, ok := n.Option(o.Key); !ok {
+			p.Options = append(p.Options, o)
+		}
+	}
+	p.Options = append(p.Options, n.Options...)
+	base.Node = p
+	base.Origin = append(base.Origin, n.Pos)
+}
+
+func (c *Config) remove(d *Directive) error {
+	for _, name := range strings.Fields(d.Value) {
+		e := c.process(name)
+		if e == nil {
+			return &Error{Pos: d.Pos, Err: fmt.Errorf("cannot remove unknown process type %q", name)}
+		}
+		e.Removed = true
+		e.Origin = append(e.Origin, d.Pos)
+	}
+	return nil
+}
+
+func (c *Config) replaceDirective(e *Entry, d *Directive) {
+	name, _, _ := lookupDirective(d.Name)
+	base := c.directive(name)
+	if base == nil {
+		c.Entries = append(c.Entries, e)
+		return
+	}
+	base.Node = d
+	base.Origin = append(base.Origin, d.Pos)
+}
+
+// patchFormation applies the formations of the overlays to the last formation
+// of the Procfile. Without one, the process types start with one instance
+// each, as when no formation is given.
+func (c *Config) patchFormation(patches []*Directive) {
+	if len(patches) == 0 {
+		return
+	}
+	e := c.directive("formation")
+	if e == nil {
+		var counts []string
+		for _, e := range c.Entries {
+			if p, ok := e.Node.(*Process); ok && !e.Removed {
+				counts = append(counts, p.Name+":1")
+			}
+		}
+		e = &Entry{Node: &Directive{Pos: patches[0].Pos, Name: "formation", Value: strings.Join(counts, " ")}}
+		c.Entries = append(c.Entries, e)
+	}
+	prev := e.Node.(*Directive)
+	d := &Directive{Pos: prev.Pos, Name: prev.Name, Value: prev.Value}
+	for _, patch := range patches {
+		d.Value = mergeFormation(d.Value, patch.Value)
+		e.Origin = append(e.Origin, patch.Pos)
+	}
+	e.Node = d
+}
+
+// mergeFormation replaces the counts of base with the ones of patch, and adds
+// the process types base does not list.
+func mergeFormation(base, patch string) string {
+	fields := strings.Fields(base)
+	for _, p := range strings.Fields(patch) {
+		name, _, _ := strings.Cut(p, ":")
+		found := false
+		for i, f := range fields {
+			if n, _, _ := strings.Cut(f, ":"); n == name {
+				fields[i], found = p, true
+			}
+		}
+		if !found {
+			fields = append(fields, p)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Print writes the configuration as a Procfile, with the origin of each entry
+// in a comment above it. Removed process types are commented out.
+func (c *Config) Print(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range c.Entries {
+		origin := make([]string, len(e.Origin))
+		for i, pos := range e.Origin {
+			origin[i] = fmt.Sprintf("%s:%d", pos.File, pos.Line)
+		}
+		if e.Removed {
+			origin[len(origin)-1] = "removed by " + origin[len(origin)-1]
+		}
+		fmt.Fprintf(bw, "# %s\n", strings.Join(origin, ", "))
+		for _, line := range strings.Split(printNode(e.Node), "\n") {
+			if e.Removed {
+				line = strings.TrimRight("# "+line, " ")
+			}
+			fmt.Fprintln(bw, line)
+		}
+	}
+	return bw.Flush()
+}
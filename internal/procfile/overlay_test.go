@@ -0,0 +1,127 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cirello.io/runner/v3/internal/runner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolve(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"Procfile": `observe: *.go
+build: go build ./...
+web: restart=fail waitfor=localhost:5432 ./server
+worker: ./worker
+db: ./db
+formation: build web worker db:1
+`,
+		"Procfile.local": `web: restart=loop ./server --debug
+db: waitfor=localhost:1
+remove: worker
+formation: db:0 extra:2
+extra: ./extra
+observe: *.go *.tmpl
+skip: build
+`,
+	})
+	base, local := filepath.Join(dir, "Procfile"), filepath.Join(dir, "Procfile.local")
+	c, err := Resolve(base, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Runner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "build", Cmd: "go build ./..."},
+		{Name: "web", Cmd: "./server --debug", WaitFor: "localhost:5432", Restart: runner.Loop},
+		{Name: "db", Cmd: "./db", WaitFor: "localhost:1"},
+		{Name: "extra", Cmd: "./extra"},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("unexpected process types:\n%v", cmp.Diff(expected, got.Processes))
+	}
+	if expected := map[string]int{"build": 1, "web": 1, "worker": 1, "db": 0, "extra": 2}; !cmp.Equal(got.Formation, expected) {
+		t.Errorf("unexpected formation:\n%v", cmp.Diff(expected, got.Formation))
+	}
+	if expected := []string{"*.go", "*.tmpl"}; !cmp.Equal(got.Observables, expected) {
+		t.Errorf("unexpected observables: %v", got.Observables)
+	}
+	if expected := []string{"build"}; !cmp.Equal(got.SkipProcs, expected) {
+		t.Errorf("unexpected skipped process types: %v", got.SkipProcs)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	printed := strings.ReplaceAll(buf.String(), dir+string(filepath.Separator), "")
+	const expectedPrint = `# Procfile:1, Procfile.local:6
+observe: *.go *.tmpl
+# Procfile:2
+build: go build ./...
+# Procfile:3, Procfile.local:1
+web: waitfor=localhost:5432 restart=loop ./server --debug
+# Procfile:4, removed by Procfile.local:3
+# worker: ./worker
+# Procfile:5, Procfile.local:2
+db: waitfor=localhost:1 ./db
+# Procfile:6, Procfile.local:4
+formation: build web worker db:0 extra:2
+# Procfile.local:5
+extra: ./extra
+# Procfile.local:7
+skip: build
+`
+	if printed != expectedPrint {
+		t.Errorf("unexpected resolved configuration:\n%s", cmp.Diff(expectedPrint, printed))
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"Procfile":       "web: ./server\n",
+		"Procfile.local": "remove: worker\n",
+	})
+	_, err := Resolve(filepath.Join(dir, "Procfile"), filepath.Join(dir, "Procfile.local"))
+	if err == nil || !strings.Contains(err.Error(), `Procfile.local:1:1: cannot remove unknown process type "worker"`) {
+		t.Errorf("removing unknown process types must fail, got: %v", err)
+	}
+}
+
+func TestResolveFormationDefaults(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"Procfile":       "web: ./server\nworker: ./worker\n",
+		"Procfile.local": "formation: worker:0\n",
+	})
+	c, err := Resolve(filepath.Join(dir, "Procfile"), filepath.Join(dir, "Procfile.local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Runner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"web": 1, "worker": 0}; !cmp.Equal(got.Formation, expected) {
+		t.Errorf("formation patches must keep the default of the other process types:\n%v", cmp.Diff(expected, got.Formation))
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Print writes the syntax tree back as a Procfile, one entry per node, in the
+// same order. Options are written before the command, and the scripts of
+// block process types are indented by two spaces.
+func Print(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	for _, n := range f.Nodes {
+		bw.WriteString(printNode(n))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func printNode(n Node) string {
+	switch n := n.(type) {
+	case *Blank:
+		return ""
+	case *Comment:
+		return n.Text
+	case *Directive:
+		return joinEntry(n.Name, n.Value)
+	case *Include:
+		path := n.Path
+		if n.Optional {
+			path = "optional=" + path
+		}
+		return joinEntry("include", path)
+	case *Process:
+		return joinEntry(n.Name, printProcessValue(n)) + printBlock(n)
+	case *BadLine:
+		return n.Text
+	default:
+		return ""
+	}
+}
+
+func printProcessValue(p *Process) string {
+	var parts []string
+	for _, o := range p.Options {
+		parts = append(parts, o.Key+"="+quote(o.Value))
+	}
+	if p.Command != "" && !p.Block {
+		parts = append(parts, p.Command)
+	}
+	return strings.Join(parts, " ")
+}
+
+// printBlock writes the script of block process types, indented under their
+// declaration.
+func printBlock(p *Process) string {
+	if !p.Block {
+		return ""
+	}
+	var sb strings.Builder
+	for _, line := range strings.Split(p.Command, "\n") {
+		sb.WriteByte('\n')
+		if line != "" {
+			sb.WriteString(blockIndent + line)
+		}
+	}
+	return sb.String()
+}
+
+// blockIndent is the indentation of the scripts of block process types.
+const blockIndent = "  "
+
+func joinEntry(name, value string) string {
+	if value == "" {
+		return name + ":"
+	}
+	return name + ": " + value
+}
+
+// quote protects option values that the shell quoting rules would split or
+// alter.
+func quote(s string) string {
+	if !strings.ContainsAny(s, " \t'\"\\") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
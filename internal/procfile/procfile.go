@@ -26,10 +26,26 @@
 //	build-server: make server
 //	web: restart=fail waitfor=localhost:8888 ./server serve
 //
+// Options of process types are the key=value words that precede the command,
+// or that follow it after a -- separator when every word after it is an
+// option. Words are split with the shell quoting rules. The rest of the line
+// is the command, kept as written.
+//
+// Lines ending with a backslash continue on the next line. A process type
+// declared without a command takes the lines indented deeper than its
+// declaration as its script:
+//
+//	web: restart=fail
+//	    ./wait-for-db
+//	    exec ./server serve
+//
+// Directives and option values can refer to variables as $VAR, ${VAR},
+// ${VAR:-default} or ${VAR:?message}, see Config.Runner.
+//
 // Special process type names:
 //
-// - workdir: the working directory. Environment variables are expanded. It
-// follows the same rules for exec.Command.Dir.
+// - workdir: the working directory. It follows the same rules for
+// exec.Command.Dir.
 //
 // - observe: a space separated list of file patterns to scan for. It uses
 // filepath.Match internally. File patterns preceded with exclamation mark (!)
@@ -42,6 +58,21 @@
 // started, format: procTypeA:# procTypeB:# ... procTypeN:#. If `procType` is
 // absent, it is not started. Empty formations start one of each process.
 //
+// - profile.<name>: a named formation, selected with the --profile flag. Its
+// entries may refer to the process types of a group as @group.
+//
+// - include: reads the process types and directives of other Procfiles in
+// place, format: [optional=]path. Relative paths are resolved from the
+// directory of the including file, and glob patterns include every matching
+// file in lexical order. Cycles are errors.
+//
+// - remove: in overlays, a space separated list of process types to drop. See
+// Resolve.
+//
+// - proxy: starts a HTTP reverse proxy that routes requests to process types
+// and round-robins across their instances, format: addr [hold=duration]
+// [host][/path]=procType ...
+//
 // - waitfor (in process type): target hostname and port that the runner will
 // probe before starting the process type.
 //
@@ -49,21 +80,70 @@
 // build; "fail" will restart the process type on failure; "loop" restart the
 // process when it naturally terminates; "temporary" runs the process only once.
 //
-// - signal (in process types): "SIGTERM", "term", or "15" terminates the
-// process; "SIGKILL", "kill", or "9" kills the process. The default is
-// "SIGKILL".
+// - outputs (in build process types): comma separated list of files produced
+// by the build step, e.g. outputs=bin/server,bin/worker. As a space ends the
+// value of an option, lists are comma separated rather than space separated.
+// After every successful build, the runner hashes them to detect which ones
+// changed.
+//
+// - after (in build process types): comma separated list of build steps that
+// must succeed before this one starts, e.g. after=build-codegen. If one of
+// them fails, this step is skipped.
+//
+// - consumes (in process types): comma separated list of build outputs the
+// process type runs, e.g. consumes=bin/server. Such process types are only
+// restarted after a build when one of their consumed outputs changed.
+//
+// - reload (in process types): signal, e.g. SIGHUP, delivered to the process
+// group instead of restarting it when only files matching reload-on change.
+//
+// - reload-on (in process types): comma separated list of file patterns that
+// trigger a reload instead of a build, e.g. reload-on=*.yaml,conf/*.toml.
+// Files that the observe patterns also match trigger both the reload and a
+// build.
+//
+// - socket (in process types): "tcp", "tcp:port" or "tcp:host:port" makes the
+// runner hold a listening socket per instance, passed to the process with the
+// systemd socket activation protocol, so restarts do not drop the port.
+//
+// - signal (in process types): signal delivered to the process group to stop
+// it, e.g. "SIGINT", "int" or "2". The default is "SIGTERM".
 //
 // - timeout (in process types): duration (in Go format) to wait after
-// sending the signal to the process.
+// sending the signal to the process, after which its process group is
+// killed. In build process types, it is the maximum duration of the step,
+// after which its process group is killed and the step is marked as
+// timed-out.
+//
+// - group (in process types): comma separated list of groups the process type
+// belongs to, e.g. group=backend,db. Groups are selected as @group.
+//
+// - env (in process types): KEY=VALUE variable set for the process type only,
+// e.g. env=NODE_ENV=development. It can be repeated.
+//
+// - envfile (in process types): comma separated list of environment files,
+// relative to workdir, read when the process type starts. It can be repeated.
+//
+// - dir (in process types): directory, relative to workdir, in which the
+// process type runs.
+//
+// - retries (in build process types): number of times a failed build step is
+// executed again before the build fails.
+//
+// - retry-delay (in build process types): duration (in Go format) between
+// attempts of a failed build step. The default is 1s.
 package procfile
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"cirello.io/runner/v3/internal/runner"
 )
@@ -87,51 +167,141 @@ func ParseFormation(s string) map[string]int {
 	return ret
 }
 
-// Parse takes a reader that contains an extended Procfile.
+// Parse takes a reader that contains an extended Procfile. Malformed lines
+// are ignored; use Check to report them.
 func Parse(r io.Reader) (*runner.Runner, error) {
-	rnr := runner.New()
-	scanner := bufio.NewScanner(preprocess(r))
-	for scanner.Scan() {
-		// loosen translation of the official regex:
-		// ^*([A-Za-z0-9_-]+):\s*(.+)$
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
-			continue
+	return parseNamed("", r)
+}
+
+// Load parses the Procfile named fn. Errors carry positions in the file.
+func Load(fn string) (*runner.Runner, error) {
+	c, err := Resolve(fn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Runner()
+}
+
+func parseNamed(fn string, r io.Reader) (*runner.Runner, error) {
+	f, err := ParseAST(fn, r)
+	if err := lenient(err); err != nil {
+		return nil, err
+	}
+	entries, err := flatten(f, newIncludeChain(fn))
+	if err != nil {
+		return nil, err
+	}
+	return (&Config{Entries: entries}).Runner()
+}
+
+// lenient drops the syntax errors that Parse tolerates.
+func lenient(err error) error {
+	var list ErrorList
+	if errors.As(err, &list) {
+		return nil
+	}
+	return err
+}
+
+// readFile parses the Procfile named fn and lists its entries.
+func readFile(fn string, chain includeChain) ([]*Entry, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseAST(fn, fd)
+	fd.Close()
+	if err := lenient(err); err != nil {
+		return nil, err
+	}
+	return flatten(f, chain)
+}
+
+// flatten lists the directives and process types of the syntax tree,
+// expanding includes in place.
+func flatten(f *File, chain includeChain) ([]*Entry, error) {
+	var entries []*Entry
+	for _, n := range f.Nodes {
+		switch n := n.(type) {
+		case *Include:
+			files, err := resolveInclude(f.Name, n)
+			if err != nil {
+				return nil, chain.error(n.Pos, fmt.Errorf("cannot include %q: %w", n.Path, err))
+			}
+			for _, fn := range files {
+				if err := chain.cycle(fn); err != nil {
+					return nil, chain.error(n.Pos, err)
+				}
+				included, err := readFile(fn, chain.push(fn, n.Pos))
+				var perr *Error
+				if err != nil && !errors.As(err, &perr) {
+					return nil, chain.error(n.Pos, fmt.Errorf("cannot include %q: %w", n.Path, err))
+				} else if err != nil {
+					return nil, err
+				}
+				entries = append(entries, included...)
+			}
+		case *Directive, *Process:
+			entries = append(entries, &Entry{Node: n, Origin: []Position{n.Position()}})
 		}
-		procType, command, found := strings.Cut(line, ":")
-		if !found {
+	}
+	return entries, nil
+}
+
+// Runner configures a runner with the entries that were not removed. Variable
+// references in directives and options are expanded with Env and the
+// environment of the runner, see Config.Env.
+func (c *Config) Runner() (*runner.Runner, error) {
+	lookup := lookupEnv(c.Env)
+	expand := func(pos Position, s string) (string, error) {
+		v, _, err := interpolate(s, lookup)
+		if err != nil {
+			return "", &Error{Pos: pos, Err: err}
+		}
+		return v, nil
+	}
+	rnr := runner.New()
+	for _, e := range c.Entries {
+		if e.Removed {
 			continue
 		}
-		procType, command = strings.TrimSpace(procType), strings.TrimSpace(command)
-		switch strings.ToLower(procType) {
-		case "workdir":
-			rnr.WorkDir = os.ExpandEnv(command)
-		case "observe", "watch":
-			rnr.Observables = strings.Split(command, " ")
-		case "ignore":
-			rnr.SkipDirs = strings.Split(command, " ")
-		case "formation":
-			rnr.Formation = ParseFormation(command)
-		case "skip":
-			rnr.SkipProcs = append(rnr.SkipProcs, strings.Fields(command)...)
-		default:
-			proc := runner.ProcessType{Name: procType}
-			parts := strings.Split(command, " ")
-			var command []string
-			for _, part := range parts {
-				if strings.HasPrefix(part, "waitfor=") {
-					proc.WaitFor = strings.TrimPrefix(part, "waitfor=")
-					continue
+		switch n := e.Node.(type) {
+		case *Directive:
+			value, err := expand(n.Pos, n.Value)
+			if err != nil {
+				return nil, err
+			}
+			canonical, kind, _ := lookupDirective(n.Name)
+			switch kind {
+			case "workdir":
+				rnr.WorkDir = value
+			case "observe":
+				rnr.Observables = strings.Split(value, " ")
+			case "ignore":
+				rnr.SkipDirs = strings.Split(value, " ")
+			case "formation":
+				rnr.Formation = ParseFormation(value)
+			case "skip":
+				rnr.SkipProcs = append(rnr.SkipProcs, strings.Fields(value)...)
+			case "proxy":
+				rnr.Proxy = ParseProxy(value)
+			case "profile":
+				if rnr.Profiles == nil {
+					rnr.Profiles = make(map[string]map[string]int)
 				}
-				if strings.HasPrefix(part, "restart=") {
-					restartMode := strings.TrimPrefix(part, "restart=")
-					proc.Restart = runner.ParseRestartMode(restartMode)
-					continue
+				rnr.Profiles[strings.TrimPrefix(canonical, "profile.")] = ParseFormation(value)
+			}
+		case *Process:
+			p := *n
+			p.Options = make([]*Option, len(n.Options))
+			for i, o := range n.Options {
+				value, err := expand(o.Pos, o.Value)
+				if err != nil {
+					return nil, err
 				}
-				command = append(command, part)
+				p.Options[i] = &Option{Pos: o.Pos, Key: o.Key, Value: value}
 			}
-			proc.Cmd = strings.TrimSpace(strings.Join(command, " "))
-			rnr.Processes = append(rnr.Processes, &proc)
+			rnr.Processes = append(rnr.Processes, buildProcess(&p))
 		}
 	}
 	if len(rnr.Formation) == 0 {
@@ -142,54 +312,108 @@ func Parse(r io.Reader) (*runner.Runner, error) {
 			}
 		}
 	}
-	return rnr, scanner.Err()
+	return rnr, nil
 }
 
-func preprocess(fd io.Reader) io.Reader {
-	r, w := io.Pipe()
-	go func() {
-		defer w.Close()
-		scanner := bufio.NewScanner(fd)
-		for scanner.Scan() {
-			// loosen translation of the official regex:
-			// ^*([A-Za-z0-9_-]+):\s*(.+)$
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
-				continue
+// buildProcess interprets the options of a process type. Invalid values are
+// ignored; use Check to report them.
+func buildProcess(n *Process) *runner.ProcessType {
+	proc := &runner.ProcessType{Name: n.Name, Cmd: n.Command}
+	for _, o := range n.Options {
+		switch o.Key {
+		case "waitfor":
+			proc.WaitFor = o.Value
+		case "restart":
+			proc.Restart = runner.ParseRestartMode(o.Value)
+		case "outputs":
+			proc.Outputs = parsePaths(o.Value)
+		case "consumes":
+			proc.Consumes = parsePaths(o.Value)
+		case "reload":
+			proc.Reload = runner.ParseSignal(o.Value)
+		case "signal":
+			proc.Signal = runner.ParseSignal(o.Value)
+		case "reload-on":
+			proc.ReloadOn = parseList(o.Value)
+		case "after":
+			proc.After = parseList(o.Value)
+		case "timeout":
+			if d, err := time.ParseDuration(o.Value); err == nil {
+				proc.Timeout = d
 			}
-			procType, command, found := strings.Cut(line, ":")
-			if !found {
-				continue
+		case "retries":
+			if n, err := strconv.Atoi(o.Value); err == nil {
+				proc.Retries = n
 			}
-			if strings.ToLower(procType) == "include" {
-				command := strings.TrimSpace(command)
-				if strings.HasPrefix(command, "optional=") {
-					command = strings.TrimPrefix(command, "optional=")
-					if _, err := os.Stat(command); err != nil {
-						continue
-					}
-				}
-				include, err := os.Open(command)
-				if err != nil {
-					w.CloseWithError(err)
-					return
-				}
-				defer include.Close()
-				if _, err := io.Copy(w, include); err != nil {
-					w.CloseWithError(err)
-					return
-				}
-				continue
+		case "retry-delay":
+			if d, err := time.ParseDuration(o.Value); err == nil {
+				proc.RetryDelay = d
 			}
-			if _, err := fmt.Fprintln(w, line); err != nil {
-				w.CloseWithError(err)
-				return
+		case "socket":
+			proc.Socket = o.Value
+		case "group":
+			proc.Groups = parseList(o.Value)
+		case "env":
+			proc.Env = append(proc.Env, o.Value)
+		case "envfile":
+			proc.EnvFiles = append(proc.EnvFiles, parseList(o.Value)...)
+		case "dir":
+			proc.Dir = o.Value
+		}
+	}
+	return proc
+}
+
+// ParseProxy interprets a string in the format "addr [hold=duration]
+// [host][/path]=procType ...". Routes whose target starts with a slash match
+// by path prefix only; otherwise, the portion up to the first slash is the
+// host to match.
+func ParseProxy(s string) *runner.Proxy {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil
+	}
+	p := &runner.Proxy{Addr: fields[0]}
+	for _, field := range fields[1:] {
+		k, v, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		if k == "hold" {
+			if d, err := time.ParseDuration(v); err == nil {
+				p.Hold = d
 			}
+			continue
 		}
-		if err := scanner.Err(); err != nil {
-			w.CloseWithError(err)
-			return
+		route := runner.ProxyRoute{ProcessType: v}
+		route.Host, route.PathPrefix, _ = strings.Cut(k, "/")
+		if strings.Contains(k, "/") {
+			route.PathPrefix = "/" + route.PathPrefix
 		}
-	}()
-	return r
+		p.Routes = append(p.Routes, route)
+	}
+	return p
+}
+
+// parseList interprets a comma separated list of values.
+// parsePaths parses a comma separated list of paths, and drops the ones that
+// are spelled differently but name the same file.
+func parsePaths(s string) []string {
+	var ret []string
+	for _, v := range parseList(s) {
+		if v = filepath.Clean(v); !slices.Contains(ret, v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func parseList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
 }
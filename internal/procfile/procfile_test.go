@@ -17,7 +17,9 @@ package procfile
 import (
 	"os"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"cirello.io/runner/v3/internal/runner"
 	"github.com/google/go-cmp/cmp"
@@ -114,3 +116,194 @@ func TestParseErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestParseBuildOutputs(t *testing.T) {
+	const example = `build: outputs=bin/server,bin/worker go build ./...
+web: consumes=bin/server ./bin/server
+worker: consumes=bin/worker ./bin/worker`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "build", Cmd: "go build ./...", Outputs: []string{"bin/server", "bin/worker"}},
+		{Name: "web", Cmd: "./bin/server", Consumes: []string{"bin/server"}},
+		{Name: "worker", Cmd: "./bin/worker", Consumes: []string{"bin/worker"}},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseBuildOutputsDuplicates(t *testing.T) {
+	const example = `build: outputs=bin/server,./bin/server,,bin//worker, go build ./...
+web: consumes=./bin/server,bin/server ./bin/server`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "build", Cmd: "go build ./...", Outputs: []string{"bin/server", "bin/worker"}},
+		{Name: "web", Cmd: "./bin/server", Consumes: []string{"bin/server"}},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseBuildAfter(t *testing.T) {
+	const example = `build-codegen: go generate ./...
+build-server: after=build-codegen,build-assets go build ./...`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "build-codegen", Cmd: "go generate ./..."},
+		{Name: "build-server", Cmd: "go build ./...", After: []string{"build-codegen", "build-assets"}},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseBuildTimeouts(t *testing.T) {
+	const example = `build: timeout=30s retries=2 retry-delay=5s go generate ./...`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "build", Cmd: "go generate ./...", Timeout: 30 * time.Second, Retries: 2, RetryDelay: 5 * time.Second},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseReload(t *testing.T) {
+	const example = `web: reload=SIGHUP reload-on=*.yaml,conf/*.toml ./server`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "web", Cmd: "./server", Reload: syscall.SIGHUP, ReloadOn: []string{"*.yaml", "conf/*.toml"}},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseGroupsAndProfiles(t *testing.T) {
+	const example = `profile.frontend-only: @frontend:1 api:2
+Profile.Backend: @backend
+web: group=frontend ./web
+api: group=backend,frontend ./api
+`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "web", Cmd: "./web", Groups: []string{"frontend"}},
+		{Name: "api", Cmd: "./api", Groups: []string{"backend", "frontend"}},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+	expectedProfiles := map[string]map[string]int{
+		"frontend-only": {"@frontend": 1, "api": 2},
+		"Backend":       {"@backend": 1},
+	}
+	if !cmp.Equal(got.Profiles, expectedProfiles) {
+		t.Errorf("parser did not get the right profiles. \n%v", cmp.Diff(got.Profiles, expectedProfiles))
+	}
+}
+
+func TestParseProcessEnvironment(t *testing.T) {
+	const example = `web: dir=frontend envfile=.env.web,.env.local env=NODE_ENV=dev env="OPTS=-a -b" npm start`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{
+			Name:     "web",
+			Cmd:      "npm start",
+			Env:      []string{"NODE_ENV=dev", "OPTS=-a -b"},
+			EnvFiles: []string{".env.web", ".env.local"},
+			Dir:      "frontend",
+		},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseStop(t *testing.T) {
+	const example = `web: signal=int timeout=10s ./server`
+	got, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []*runner.ProcessType{
+		{Name: "web", Cmd: "./server", Signal: syscall.SIGINT, Timeout: 10 * time.Second},
+	}
+	if !cmp.Equal(got.Processes, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	got := ParseProxy("localhost:8080 hold=5s api.localhost=api /static=assets /=web")
+	expected := &runner.Proxy{
+		Addr: "localhost:8080",
+		Hold: 5 * time.Second,
+		Routes: []runner.ProxyRoute{
+			{Host: "api.localhost", ProcessType: "api"},
+			{PathPrefix: "/static", ProcessType: "assets"},
+			{PathPrefix: "/", ProcessType: "web"},
+		},
+	}
+	if !cmp.Equal(got, expected) {
+		t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got, expected))
+	}
+	if got := ParseProxy("   "); got != nil {
+		t.Errorf("empty proxy directives must disable the proxy, got: %v", got)
+	}
+}
+
+func TestParseCommandTokenization(t *testing.T) {
+	tests := []struct {
+		line    string
+		cmd     string
+		restart runner.RestartMode
+		waitFor string
+	}{
+		{line: `web: ./server --restart=never`, cmd: `./server --restart=never`},
+		{line: `web: ./server restart=fail`, cmd: `./server restart=fail`},
+		{line: `web: echo "a  b"`, cmd: `echo "a  b"`},
+		{line: `web: restart=fail echo 'restart=loop'  "x"`, cmd: `echo 'restart=loop'  "x"`, restart: runner.OnFailure},
+		{line: `web: waitfor="localhost:8080" restart=fail ./server`, cmd: `./server`, restart: runner.OnFailure, waitFor: "localhost:8080"},
+		{line: `web: "restart=fail" ./server`, cmd: `"restart=fail" ./server`},
+		{line: `web: FOO=bar restart=fail ./server`, cmd: `FOO=bar restart=fail ./server`},
+		{line: `web: echo a\ b -- c`, cmd: `echo a\ b -- c`},
+		{line: `web: ./server serve -- restart=fail waitfor=localhost:1`, cmd: `./server serve`, restart: runner.OnFailure, waitFor: "localhost:1"},
+		{line: `web: go test ./... -- -run restart=fail`, cmd: `go test ./... -- -run restart=fail`},
+		{line: `web: echo "unterminated -- restart=fail`, cmd: `echo "unterminated -- restart=fail`},
+		{line: `web: echo "say \"hi\"" -- restart=fail`, cmd: `echo "say \"hi\""`, restart: runner.OnFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.line))
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			expected := []*runner.ProcessType{{Name: "web", Cmd: tt.cmd, Restart: tt.restart, WaitFor: tt.waitFor}}
+			if !cmp.Equal(got.Processes, expected) {
+				t.Errorf("parser did not get the right result. \n%v", cmp.Diff(got.Processes, expected))
+			}
+		})
+	}
+}
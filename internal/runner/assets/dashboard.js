@@ -0,0 +1,250 @@
+(function() {
+	const maxLines = 5000;
+	const output = document.getElementById("output");
+	const hiddenProcs = new Set();
+	const knownProcs = new Set();
+	let pending = [];
+
+	function escapeHTML(s) {
+		const d = document.createElement("div");
+		d.innerText = s;
+		return d.innerHTML;
+	}
+
+	function searchTerm() {
+		return document.getElementById("search").value;
+	}
+
+	function decorate(line) {
+		line.classList.toggle("hidden", hiddenProcs.has(line.dataset.name));
+		const term = searchTerm();
+		line.classList.toggle("match", term !== "" && line.textContent.indexOf(term) > -1);
+	}
+
+	function addProcFilter(name) {
+		if (knownProcs.has(name)) {
+			return;
+		}
+		knownProcs.add(name);
+		const label = document.createElement("label");
+		const input = document.createElement("input");
+		input.type = "checkbox";
+		input.checked = true;
+		input.addEventListener("change", function() {
+			if (input.checked) {
+				hiddenProcs.delete(name);
+			} else {
+				hiddenProcs.add(name);
+			}
+			output.childNodes.forEach(decorate);
+		});
+		label.appendChild(input);
+		label.appendChild(document.createTextNode(" " + name));
+		document.getElementById("procFilters").appendChild(label);
+	}
+
+	function print(name, html) {
+		const d = document.createElement("div");
+		d.dataset.name = name;
+		d.innerHTML = html;
+		decorate(d);
+		output.appendChild(d);
+		while (output.childNodes.length > maxLines) {
+			output.removeChild(output.firstChild);
+		}
+	}
+
+	function flush() {
+		for (const msg of pending) {
+			addProcFilter(msg.name);
+			print(msg.name, escapeHTML(msg.paddedName) + ": " + msg.line);
+		}
+		pending = [];
+		if (document.getElementById("autoScroll").checked) {
+			window.scrollTo(0, document.body.scrollHeight);
+		}
+	}
+
+	function dialLogs() {
+		const es = new EventSource(output.dataset.url);
+		es.onopen = function() {
+			print("", "connected...");
+		};
+		es.onmessage = function(evt) {
+			pending.push(JSON.parse(evt.data));
+			if (pending.length > maxLines) {
+				pending = pending.slice(-maxLines);
+			}
+			if (!document.getElementById("pause").checked) {
+				flush();
+			}
+		};
+		es.onerror = function() {
+			print("", "ERROR: disconnected");
+			es.close();
+			setTimeout(dialLogs, 1000);
+		};
+	}
+
+	function uptime(startedAt) {
+		let s = Math.max(0, Math.floor((Date.now() - new Date(startedAt).getTime()) / 1000));
+		const h = Math.floor(s / 3600);
+		s -= h * 3600;
+		const m = Math.floor(s / 60);
+		s -= m * 60;
+		return (h > 0 ? h + "h" : "") + (h > 0 || m > 0 ? m + "m" : "") + s + "s";
+	}
+
+	function control(name, action) {
+		fetch("/processes/" + encodeURIComponent(name) + "/" + action, {method: "POST"})
+			.then(function(resp) {
+				if (!resp.ok) {
+					return resp.text().then(window.alert);
+				}
+			})
+			.then(updateProcesses);
+	}
+
+	function updateProcesses() {
+		fetch("/processes").then(function(resp) {
+			return resp.json();
+		}).then(function(procs) {
+			const tbody = document.getElementById("processTable");
+			tbody.innerHTML = "";
+			for (const p of procs) {
+				const tr = document.createElement("tr");
+				const running = p.state === "running";
+				tr.innerHTML =
+					"<td>" + escapeHTML(p.name) + "</td>" +
+					'<td><span class="state ' + escapeHTML(p.state) + '">' + escapeHTML(p.state) + "</span></td>" +
+					"<td>" + (p.port || "") + "</td>" +
+					"<td>" + (p.pid || "") + "</td>" +
+					"<td>" + (running ? uptime(p.startedAt) : "") + "</td>" +
+					"<td>" + p.restarts + "</td>" +
+					"<td></td>";
+				const actions = tr.lastChild;
+				for (const action of ["restart", "stop", "start"]) {
+					const b = document.createElement("button");
+					b.type = "button";
+					b.innerText = action;
+					b.disabled = (action === "start" && p.state !== "stopped") || (action === "stop" && p.state === "stopped");
+					b.addEventListener("click", function() {
+						control(p.name, action);
+					});
+					actions.appendChild(b);
+				}
+				tbody.appendChild(tr);
+			}
+		}).catch(function(err) {
+			console.log("cannot load processes:", err);
+		});
+	}
+
+	let lastErr = "";
+	function updateBuilds() {
+		fetch("/state").then(function(resp) {
+			return resp.json();
+		}).then(function(svcs) {
+			let svc = "";
+			let errors = "";
+			for (const i in svcs) {
+				if (i.indexOf("ERROR_") === 0) {
+					errors += "\n" + escapeHTML(i.substring(6)) + "\n" + escapeHTML(svcs[i]) + "\n<hr/>";
+					continue;
+				}
+				svc += '<img class="badges" alt="' + escapeHTML(i + ": " + svcs[i]) + '" src="/badges/' +
+					encodeURIComponent(i) + '.svg?state=' + encodeURIComponent(svcs[i]) + '"/> ';
+			}
+			document.getElementById("status").innerHTML = svc || "<em>no build steps</em>";
+			if (errors !== lastErr) {
+				lastErr = errors;
+				document.getElementById("build_errors").innerHTML = errors;
+			}
+		}).catch(function(err) {
+			console.log("cannot load build state:", err);
+		});
+	}
+
+	function updateDiagnostics() {
+		fetch("/diagnostics").then(function(resp) {
+			return resp.json();
+		}).then(function(diags) {
+			const ul = document.getElementById("diagnostics");
+			ul.innerHTML = "";
+			for (const d of diags) {
+				const li = document.createElement("li");
+				const pos = d.file + ":" + d.line + (d.column ? ":" + d.column : "");
+				const where = d.url ? '<a href="' + escapeHTML(d.url) + '">' + escapeHTML(pos) + "</a>" : escapeHTML(pos);
+				li.innerHTML = escapeHTML(d.step) + ": " + where +
+					' <span class="' + escapeHTML(d.severity) + '">' + escapeHTML(d.severity) + "</span> " + escapeHTML(d.message);
+				ul.appendChild(li);
+			}
+		}).catch(function(err) {
+			console.log("cannot load diagnostics:", err);
+		});
+	}
+
+	function updateBuildHistory() {
+		fetch("/builds").then(function(resp) {
+			return resp.json();
+		}).then(function(builds) {
+			const history = document.getElementById("buildHistory");
+			history.innerHTML = "";
+			for (const b of builds.reverse()) {
+				const li = document.createElement("li");
+				li.value = b.id;
+				const steps = b.steps.map(function(step) {
+					const name = step.attempt > 1 ? step.name + " #" + step.attempt : step.name;
+					return escapeHTML(name) + ' <span class="state ' + escapeHTML(step.status) + '">' +
+						escapeHTML(step.status) + "</span> " + (step.duration / 1e9).toFixed(1) + "s";
+				}).join(", ");
+				li.innerHTML = '<a href="/builds/' + b.id + '">' + new Date(b.start).toLocaleTimeString() + "</a> " +
+					escapeHTML(b.trigger || "initial build") +
+					' <span class="state ' + escapeHTML(b.status) + '">' + escapeHTML(b.status) + "</span> " + steps;
+				history.appendChild(li);
+			}
+		}).catch(function(err) {
+			console.log("cannot load build history:", err);
+		});
+	}
+
+	function dialEvents() {
+		const es = new EventSource("/events");
+		es.onmessage = function(evt) {
+			const ev = JSON.parse(evt.data);
+			if (ev.type !== "build") {
+				return;
+			}
+			updateBuilds();
+			updateBuildHistory();
+			updateDiagnostics();
+		};
+		es.onerror = function() {
+			es.close();
+			setTimeout(dialEvents, 1000);
+		};
+	}
+
+	window.addEventListener("load", function() {
+		document.getElementById("pause").addEventListener("change", function() {
+			if (!this.checked) {
+				flush();
+			}
+		});
+		document.getElementById("search").addEventListener("input", function() {
+			output.childNodes.forEach(decorate);
+		});
+		document.getElementById("clear").addEventListener("click", function() {
+			output.innerHTML = "";
+			pending = [];
+		});
+		dialLogs();
+		dialEvents();
+		updateProcesses();
+		updateBuilds();
+		updateBuildHistory();
+		updateDiagnostics();
+		setInterval(updateProcesses, 1000);
+		setInterval(updateBuilds, 5000);
+	});
+})();
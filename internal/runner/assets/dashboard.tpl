@@ -0,0 +1,47 @@
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>runner</title>
+<link rel="stylesheet" href="/assets/dashboard.css">
+</head>
+<body>
+<div id="panels">
+	<section id="processes">
+		<h2>processes</h2>
+		<table>
+			<thead>
+				<tr><th>name</th><th>state</th><th>port</th><th>pid</th><th>uptime</th><th>restarts</th><th></th></tr>
+			</thead>
+			<tbody id="processTable"><tr><td colspan="7"><em>loading...</em></td></tr></tbody>
+		</table>
+	</section>
+	<section id="builds">
+		<h2>builds</h2>
+		<div id="status"><em>loading...</em></div>
+		<ul id="diagnostics"></ul>
+		<pre id="build_errors"></pre>
+		<h3>history</h3>
+		<ol id="buildHistory" reversed></ol>
+	</section>
+</div>
+<div id="controlBar">
+	<form id="filterForm">
+		<label><input type="checkbox" id="autoScroll" checked> auto scroll</label>
+		|
+		<label><input type="checkbox" id="pause"> pause</label>
+		|
+		<label><input type="text" id="filter" name="filter" placeholder="filter" value="{{.Filter}}"></label>
+		<input type=submit style="display: none">
+		|
+		<label><input type="search" id="search" placeholder="highlight"></label>
+		|
+		<button type="button" id="clear">clear</button>
+		|
+		<span id="procFilters"></span>
+	</form>
+</div>
+<div id="output" data-url="{{.URL}}"></div>
+<script src="/assets/dashboard.js"></script>
+</body>
+</html>
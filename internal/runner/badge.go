@@ -0,0 +1,93 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// badgeColor picks the color of the status side of a badge.
+func badgeColor(status string) string {
+	switch status {
+	case BuildDone, StateRunning:
+		return "#4c1"
+	case BuildRunning, BuildCached, StatePending, StateWaiting:
+		return "#007ec6"
+	case BuildErrored, BuildTimedOut, StateFailed:
+		return "#e05d44"
+	default:
+		return "#9f9f9f"
+	}
+}
+
+// renderBadge draws a two-sided badge, in the same style as the ones served
+// by shields.io, so the dashboard does not depend on third party services.
+func renderBadge(label, status string) []byte {
+	// Verdana 11px averages 7px per character, plus 10px of padding.
+	labelWidth := 7*len(label) + 10
+	statusWidth := 7*len(status) + 10
+	width := labelWidth + statusWidth
+	color := badgeColor(status)
+	label, status = html.EscapeString(label), html.EscapeString(status)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, statusWidth, label, status, color, labelWidth/2, labelWidth+statusWidth/2))
+}
+
+// badgeStatus finds the state of a build step, by its normalized name, or of
+// a process instance.
+func (r *Runner) badgeStatus(name string) string {
+	r.servicesMu.Lock()
+	status, ok := r.serviceStates[name]
+	r.servicesMu.Unlock()
+	if ok {
+		return status
+	}
+	for _, ps := range r.processStates() {
+		if ps.Name == name {
+			return ps.State
+		}
+	}
+	return "unknown"
+}
+
+func (r *Runner) serveBadges(mux *http.ServeMux) {
+	mux.HandleFunc("GET /badges/{file}", func(w http.ResponseWriter, req *http.Request) {
+		name, ok := strings.CutSuffix(req.PathValue("file"), ".svg")
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+		if _, err := w.Write(renderBadge(name, r.badgeStatus(name))); err != nil {
+			log.Println("cannot serve badge:", err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// stateDirName is the directory, relative to WorkDir, in which the runner
+// persists its state across restarts.
+const stateDirName = ".runner"
+
+const buildCacheFileName = "buildcache.json"
+
+// buildCache maps build step names to the hash of the inputs of their last
+// successful run.
+type buildCache struct {
+	fn string
+
+	mu    sync.Mutex
+	Steps map[string]string `json:"steps"`
+}
+
+func loadBuildCache(dir string) *buildCache {
+	c := &buildCache{
+		fn:    filepath.Join(dir, buildCacheFileName),
+		Steps: make(map[string]string),
+	}
+	b, err := os.ReadFile(c.fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c
+	} else if err != nil {
+		log.Println("cannot read build cache:", err)
+		return c
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		log.Println("cannot decode build cache, starting afresh:", err)
+		c.Steps = make(map[string]string)
+	}
+	return c
+}
+
+func (c *buildCache) hit(step, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Steps[step] == key
+}
+
+func (c *buildCache) store(step, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Steps[step] = key
+	if err := os.MkdirAll(filepath.Dir(c.fn), 0o755); err != nil {
+		log.Println("cannot create state directory:", err)
+		return
+	}
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		log.Println("cannot encode build cache:", err)
+		return
+	}
+	if err := os.WriteFile(c.fn, b, 0o644); err != nil {
+		log.Println("cannot write build cache:", err)
+	}
+}
+
+// inputsDigest hashes the path and the content of every file in the workdir
+// that matches the observed patterns. Negated patterns exclude files from
+// the digest.
+func (r *Runner) inputsDigest() string {
+	h := sha256.New()
+	_ = filepath.WalkDir(r.WorkDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if r.isSkippedDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !r.isInput(path) {
+			return nil
+		}
+		fileHash, err := hashFile(path)
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(r.WorkDir, path)
+		fmt.Fprintf(h, "%s\x00%s\n", rel, fileHash)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *Runner) isSkippedDir(path string) bool {
+	if path == filepath.Join(r.WorkDir, stateDirName) || path == filepath.Join(r.WorkDir, ".git") {
+		return true
+	}
+	for _, skipDir := range r.SkipDirs {
+		if skipDir == "" {
+			continue
+		}
+		if strings.HasPrefix(path, filepath.Join(r.WorkDir, skipDir)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) isInput(path string) bool {
+	var matched bool
+	for _, p := range r.Observables {
+		if negated, ok := strings.CutPrefix(p, "!"); ok {
+			if match(negated, path) {
+				return false
+			}
+			continue
+		}
+		if match(p, path) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// buildStepKey combines the inputs digest with everything else that can
+// change the result of a build step: its command, its directory and its
+// environment; and with the hashes of its declared outputs, so that the step
+// runs again when they are missing or were changed since it last succeeded.
+// Unreadable environment files are left out, as the step fails before running
+// anyway.
+func (r *Runner) buildStepKey(sv *ProcessType, inputsDigest string) string {
+	h := sha256.New()
+	fmt.Fprintln(h, inputsDigest)
+	fmt.Fprintln(h, sv.Cmd)
+	fmt.Fprintln(h, r.processDir(sv))
+	env, _ := r.processEnv(sv)
+	slices.Sort(env)
+	for _, kv := range env {
+		fmt.Fprintln(h, kv)
+	}
+	for _, output := range sv.Outputs {
+		output = filepath.Clean(output)
+		digest, err := hashFile(filepath.Join(r.WorkDir, output))
+		if err != nil {
+			digest = "missing"
+		}
+		fmt.Fprintln(h, output, digest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashFile(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
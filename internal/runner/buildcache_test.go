@@ -0,0 +1,133 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputsDigest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.go", "package main")
+	write("main_test.go", "package main")
+	write("vendor/dep.go", "package dep")
+	r := New()
+	r.WorkDir = dir
+	r.Observables = []string{"!*_test.go", "*.go"}
+	r.SkipDirs = []string{"vendor"}
+	original := r.inputsDigest()
+
+	now := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "main.go"), now, now); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.inputsDigest(); got != original {
+		t.Error("touching a file must not change the digest")
+	}
+	write("main_test.go", "package main // changed")
+	if got := r.inputsDigest(); got != original {
+		t.Error("files matching negated patterns must not change the digest")
+	}
+	write("vendor/dep.go", "package dep // changed")
+	if got := r.inputsDigest(); got != original {
+		t.Error("files in skipped directories must not change the digest")
+	}
+	write(filepath.Join(stateDirName, "state.go"), "package state")
+	if got := r.inputsDigest(); got != original {
+		t.Error("files in the state directory must not change the digest")
+	}
+	write("main.go", "package main // changed")
+	if got := r.inputsDigest(); got == original {
+		t.Error("changing the content of an input must change the digest")
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	dir := t.TempDir()
+	c := loadBuildCache(dir)
+	if c.hit("build", "key") {
+		t.Fatal("empty cache must not hit")
+	}
+	c.store("build", "key")
+	reloaded := loadBuildCache(dir)
+	if !reloaded.hit("build", "key") {
+		t.Error("cache must survive reloads")
+	}
+	if reloaded.hit("build", "other-key") {
+		t.Error("cache must miss on different keys")
+	}
+}
+
+func TestBuildCacheOutputs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	counter := filepath.Join(dir, "counter")
+	server := filepath.Join(dir, "bin", "server")
+	r := New()
+	r.WorkDir = dir
+	r.buildCache = loadBuildCache(filepath.Join(dir, stateDirName))
+	r.Processes = []*ProcessType{{
+		Name:    "build",
+		Cmd:     "echo . >> " + counter + "; echo server > " + server,
+		Outputs: []string{"bin/server"},
+	}}
+	r.Formation["build"] = 1
+	runs := func() int {
+		t.Helper()
+		if !r.runBuilds(context.Background(), "", "digest") {
+			t.Fatal("build failed")
+		}
+		b, _ := os.ReadFile(counter)
+		return strings.Count(string(b), ".")
+	}
+	if got := runs(); got != 1 {
+		t.Fatalf("the first build must run, got %d runs", got)
+	}
+	if got := runs(); got != 1 {
+		t.Errorf("unchanged builds must be skipped, got %d runs", got)
+	}
+	if err := os.Remove(server); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs(); got != 2 {
+		t.Errorf("builds with missing outputs must run, got %d runs", got)
+	}
+	if err := os.WriteFile(server, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs(); got != 3 {
+		t.Errorf("builds with changed outputs must run, got %d runs", got)
+	}
+	if got := runs(); got != 3 {
+		t.Errorf("unchanged builds must be skipped, got %d runs", got)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cirello.io/runner/v3/internal/diagnostics"
+)
+
+const diagnosticsFileName = "diagnostics.json"
+
+// BuildDiagnostic is a problem found in the output of a failed build step.
+type BuildDiagnostic struct {
+	Step string `json:"step"`
+	diagnostics.Diagnostic
+
+	// URL opens the file at the diagnostic position in an editor. It is
+	// only set if the runner has an EditorURL template.
+	URL string `json:"url,omitempty"`
+}
+
+// setDiagnostics replaces the diagnostics of a build step with the ones found
+// in its output, and persists all diagnostics in the state directory so
+// editors can display them. The paths in the output are relative to the
+// directory of the step, and the diagnostics are relative to WorkDir.
+func (r *Runner) setDiagnostics(sv *ProcessType, output string) {
+	step := sv.Name
+	stepDir := r.processDir(sv)
+	var diags []BuildDiagnostic
+	for _, d := range diagnostics.Parse(output, stepDir) {
+		d.File = r.rebase(stepDir, d.File)
+		diags = append(diags, BuildDiagnostic{
+			Step:       step,
+			Diagnostic: d,
+			URL:        diagnostics.EditorURL(r.EditorURL, d, r.WorkDir),
+		})
+	}
+	r.diagnosticsMu.Lock()
+	defer r.diagnosticsMu.Unlock()
+	if len(diags) == 0 && len(r.diagnostics[step]) == 0 {
+		return
+	}
+	if len(diags) == 0 {
+		delete(r.diagnostics, step)
+	} else {
+		r.diagnostics[step] = diags
+	}
+	b, err := json.MarshalIndent(r.diagnosticsLocked(), "", "    ")
+	if err != nil {
+		log.Println("cannot encode diagnostics:", err)
+		return
+	}
+	dir := filepath.Join(r.WorkDir, stateDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Println("cannot create state directory:", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, diagnosticsFileName), b, 0o644); err != nil {
+		log.Println("cannot write diagnostics:", err)
+	}
+}
+
+// rebase makes the path of a file, relative to dir, relative to WorkDir. Files
+// outside of WorkDir get absolute paths.
+func (r *Runner) rebase(dir, file string) string {
+	if filepath.IsAbs(file) || dir == r.WorkDir {
+		return file
+	}
+	file = filepath.Join(dir, file)
+	if rel, err := filepath.Rel(r.WorkDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// Diagnostics lists the problems found in the output of the failed build
+// steps, in order of declaration of the steps.
+func (r *Runner) Diagnostics() []BuildDiagnostic {
+	r.diagnosticsMu.Lock()
+	defer r.diagnosticsMu.Unlock()
+	return r.diagnosticsLocked()
+}
+
+func (r *Runner) diagnosticsLocked() []BuildDiagnostic {
+	diags := []BuildDiagnostic{}
+	for _, sv := range r.Processes {
+		diags = append(diags, r.diagnostics[sv.Name]...)
+	}
+	return diags
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cirello.io/runner/v3/internal/envfile"
+)
+
+// ErrUnknownProcessType is returned by Environment for names that do not match
+// any process type.
+var ErrUnknownProcessType = errors.New("unknown process type")
+
+// processEnv lists the environment of the process type, from the lowest to
+// the highest precedence: the environment of the runner, and the configured
+// variables of processVars.
+func (r *Runner) processEnv(sv *ProcessType) ([]string, error) {
+	vars, err := r.processVars(sv)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(os.Environ(), vars), nil
+}
+
+// processVars lists the variables configured for the process type, from the
+// lowest to the highest precedence: BaseEnvironment, the EnvFiles of the
+// process type, in order, and its Env.
+func (r *Runner) processVars(sv *ProcessType) ([]string, error) {
+	env := slices.Clone(r.BaseEnvironment)
+	for _, fn := range sv.EnvFiles {
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(r.WorkDir, fn)
+		}
+		fd, err := os.Open(fn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read environment file: %w", err)
+		}
+		vars, err := envfile.Parse(fd)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse environment file %s: %w", fn, err)
+		}
+		env = append(env, vars...)
+	}
+	return append(env, sv.Env...), nil
+}
+
+// processDir is the directory in which the process type runs.
+func (r *Runner) processDir(sv *ProcessType) string {
+	switch {
+	case sv.Dir == "":
+		return r.WorkDir
+	case filepath.IsAbs(sv.Dir):
+		return sv.Dir
+	}
+	return filepath.Join(r.WorkDir, sv.Dir)
+}
+
+// instancePort decides the port of a process instance without socket, given
+// the variables configured for it and its port offset, -1 for build steps. A
+// PORT set in the configured variables is kept, otherwise instances get
+// BasePort plus their offset. It returns the port to announce in PORT, zero
+// when there is none or it is already set, and the address the instance
+// listens on, if known.
+func (r *Runner) instancePort(vars []string, portCount int) (int, string) {
+	for i := len(vars) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(vars[i], "PORT="); ok {
+			if _, err := strconv.Atoi(v); err != nil {
+				return 0, ""
+			}
+			return 0, "localhost:" + v
+		}
+	}
+	if r.BasePort <= 0 || portCount < 0 {
+		return 0, ""
+	}
+	port := r.BasePort + portCount
+	return port, fmt.Sprintf("localhost:%v", port)
+}
+
+// runnerEnv lists the variables the runner sets for a process instance, which
+// take precedence over processEnv. A zero port is not announced.
+func (r *Runner) runnerEnv(procName string, port int, socket bool, changedFileName string) []string {
+	var env []string
+	if socket {
+		env = append(env, "LISTEN_FDS=1", fmt.Sprintf("LISTEN_FDNAMES=%v", procName))
+	}
+	if port > 0 {
+		env = append(env, fmt.Sprintf("PORT=%v", port))
+	}
+	env = append(env, fmt.Sprintf("PS=%v", procName))
+	if r.ServiceDiscoveryAddr != "" {
+		env = append(env, fmt.Sprintf("DISCOVERY=%v", r.ServiceDiscoveryAddr))
+	}
+	return append(env, fmt.Sprintf("CHANGED_FILENAME=%v", changedFileName))
+}
+
+// Environment returns the environment that the named process instance, like
+// web.1, or build step would receive, sorted by name. A process type name
+// stands for its first instance. From the lowest to the highest precedence,
+// it combines:
+//
+//   - the environment of the runner;
+//   - BaseEnvironment, loaded from the --env file;
+//   - the EnvFiles of the process type, in order;
+//   - the Env of the process type, in order;
+//   - the variables set by the runner: PS, DISCOVERY, CHANGED_FILENAME, the
+//     socket activation variables and PORT, unless one of the sources above,
+//     but the environment of the runner, sets it. See instancePort.
+//
+// The port of instances with an ephemeral socket is only known once they
+// start, so PORT is missing for them.
+func (r *Runner) Environment(name string) ([]string, error) {
+	procType, instance := name, 0
+	if base, n, found := strings.Cut(name, "."); found {
+		if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+			procType, instance = base, i
+		}
+	}
+	for j, sv := range r.Processes {
+		if sv.Name != procType {
+			continue
+		}
+		vars, err := r.processVars(sv)
+		if err != nil {
+			return nil, err
+		}
+		env := slices.Concat(os.Environ(), vars)
+		if strings.HasPrefix(sv.Name, "build") {
+			env = append(env, r.runnerEnv(sv.Name, 0, false, "")...)
+			return dedupEnv(env), nil
+		}
+		port := 0
+		if sv.Socket != "" {
+			if addr, err := socketAddress(sv.Socket, instance); err == nil {
+				_, p, _ := net.SplitHostPort(addr)
+				port, _ = strconv.Atoi(p)
+			}
+		} else {
+			port, _ = r.instancePort(vars, j*100+instance)
+		}
+		env = append(env, r.runnerEnv(instanceName(sv.Name, instance), port, sv.Socket != "", "")...)
+		return dedupEnv(env), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownProcessType, procType)
+}
+
+// dedupEnv keeps the last value of each variable, as exec.Cmd does, and sorts
+// them by name.
+func dedupEnv(env []string) []string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		vars[k] = kv
+	}
+	ret := make([]string, 0, len(vars))
+	for _, k := range slices.Sorted(maps.Keys(vars)) {
+		ret = append(ret, vars[k])
+	}
+	return ret
+}
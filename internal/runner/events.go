@@ -0,0 +1,72 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"slices"
+	"time"
+)
+
+// Event types
+const (
+	EventBuild      = "build"
+	EventReload     = "reload"
+	EventLiveReload = "livereload"
+	EventTask       = "task"
+)
+
+// Event describes a change in the lifecycle of the runner or of one of its
+// processes. Events are broadcasted through the /events endpoint.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Name   string    `json:"name,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+const eventSubscriberBufferSize = 1024
+
+func (r *Runner) subscribeEvents() chan Event {
+	stream := make(chan Event, eventSubscriberBufferSize)
+	r.eventsMu.Lock()
+	r.eventSubscribers = append(r.eventSubscribers, stream)
+	r.eventsMu.Unlock()
+	return stream
+}
+
+func (r *Runner) unsubscribeEvents(stream chan Event) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	r.eventSubscribers = slices.DeleteFunc(r.eventSubscribers, func(i chan Event) bool {
+		return i == stream
+	})
+}
+
+func (r *Runner) publish(typ, name, detail string) {
+	ev := Event{
+		Time:   time.Now(),
+		Type:   typ,
+		Name:   name,
+		Detail: detail,
+	}
+	r.eventsMu.RLock()
+	defer r.eventsMu.RUnlock()
+	for _, subscriber := range r.eventSubscribers {
+		select {
+		case subscriber <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Health is the aggregate readiness of the application.
+type Health struct {
+	// Ready is true when the last build succeeded and all the considered
+	// instances are running.
+	Ready bool `json:"ready"`
+
+	// Build is the status of the last build, empty if none started yet.
+	Build string `json:"build"`
+
+	// Pending are the instances that are not running yet, or that failed.
+	Pending []ProcessState `json:"pending"`
+}
+
+// health computes the readiness of the application. If procs is not empty,
+// only the instances of these process types, or these instances, are
+// considered. Instances of temporary process types, the ones stopped through
+// the controls and the ones that exited successfully are never considered.
+func (r *Runner) health(procs []string) Health {
+	h := Health{Pending: []ProcessState{}}
+	if builds := r.Builds(); len(builds) > 0 {
+		h.Build = builds[len(builds)-1].Status
+	}
+	restartModes := make(map[string]RestartMode)
+	for _, sv := range r.Processes {
+		restartModes[sv.Name] = sv.Restart
+	}
+	for _, ps := range r.processStates() {
+		if len(procs) > 0 && !slices.Contains(procs, ps.ProcessType) && !slices.Contains(procs, ps.Name) {
+			continue
+		}
+		mode := restartModes[ps.ProcessType]
+		switch {
+		case mode == Temporary, ps.State == StateStopped, ps.State == StateRunning:
+			continue
+		case ps.State == StateExited:
+			// finished successfully, or in between iterations of
+			// loop process types.
+			continue
+		}
+		h.Pending = append(h.Pending, ps)
+	}
+	h.Ready = h.Build == BuildDone && len(h.Pending) == 0
+	return h
+}
+
+// serveHealth serves the readiness of the application at /healthz. It answers
+// 200 when ready and 503 otherwise; the body details what is pending. The proc
+// query parameter, which can be repeated, restricts the instances considered.
+func (r *Runner) serveHealth(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, req *http.Request) {
+		var procs []string
+		for _, p := range req.URL.Query()["proc"] {
+			procs = append(procs, strings.Fields(p)...)
+		}
+		h := r.health(procs)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if !h.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(h); err != nil {
+			log.Println("cannot serve health request:", err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultBuildHistorySize is the number of builds kept in the history when
+// Runner.BuildHistorySize is zero.
+const DefaultBuildHistorySize = 50
+
+// maxBuildStepOutput bounds the captured output of each build step kept in
+// the history. Only the tail is preserved.
+const maxBuildStepOutput = 64 * 1024
+
+// Build statuses
+const (
+	BuildRunning  = "building"
+	BuildDone     = "done"
+	BuildErrored  = "errored"
+	BuildCached   = "cached"
+	BuildSkipped  = "skipped"
+	BuildTimedOut = "timed-out"
+)
+
+// Build is the record of one run of the build steps.
+type Build struct {
+	// ID is the generation of the build, it increases by one at every run.
+	ID int `json:"id"`
+
+	// Trigger is the file, relative to WorkDir, whose change started the
+	// build. It is empty for the initial build.
+	Trigger string `json:"trigger"`
+
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Status string       `json:"status"`
+	Steps  []*BuildStep `json:"steps"`
+}
+
+// BuildStep is the record of one execution of a build process type.
+type BuildStep struct {
+	Name     string        `json:"name"`
+	Attempt  int           `json:"attempt"`
+	Status   string        `json:"status"`
+	ExitCode int           `json:"exitCode"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	Output   string        `json:"output,omitempty"`
+}
+
+// Duration is how long the whole build took.
+func (b *Build) Duration() time.Duration {
+	if b.End.IsZero() {
+		return time.Since(b.Start)
+	}
+	return b.End.Sub(b.Start)
+}
+
+// newBuild records the start of a new generation of builds.
+func (r *Runner) newBuild(changedFileName string) *Build {
+	if rel, err := filepath.Rel(r.WorkDir, changedFileName); err == nil && !strings.HasPrefix(rel, "..") {
+		changedFileName = rel
+	}
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	r.buildGeneration++
+	b := &Build{
+		ID:      r.buildGeneration,
+		Trigger: changedFileName,
+		Start:   time.Now(),
+		Status:  BuildRunning,
+	}
+	size := r.BuildHistorySize
+	if size <= 0 {
+		size = DefaultBuildHistorySize
+	}
+	r.history = append(r.history, b)
+	if len(r.history) > size {
+		r.history = r.history[len(r.history)-size:]
+	}
+	return b
+}
+
+// addStep appends a step to the build record. Attempt is zero for steps that
+// are not executed, like cached or skipped ones.
+func (r *Runner) addStep(b *Build, name string, attempt int) *BuildStep {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	step := &BuildStep{
+		Name:    name,
+		Attempt: attempt,
+		Status:  BuildRunning,
+		Start:   time.Now(),
+	}
+	b.Steps = append(b.Steps, step)
+	return step
+}
+
+// finishStep records the outcome of a build step execution.
+func (r *Runner) finishStep(step *BuildStep, status string, err error, output string) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	step.Status = status
+	step.End = time.Now()
+	step.Duration = step.End.Sub(step.Start)
+	step.ExitCode = exitCode(err)
+	if len(output) > maxBuildStepOutput {
+		output = output[len(output)-maxBuildStepOutput:]
+	}
+	step.Output = output
+}
+
+// finishBuild records the outcome of the build.
+func (r *Runner) finishBuild(b *Build, ok bool) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	b.End = time.Now()
+	b.Status = BuildDone
+	if !ok {
+		b.Status = BuildErrored
+	}
+}
+
+// Builds lists the build history, from the oldest to the newest build. Step
+// outputs are omitted.
+func (r *Runner) Builds() []Build {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	builds := make([]Build, 0, len(r.history))
+	for _, b := range r.history {
+		summary := *b
+		summary.Steps = make([]*BuildStep, 0, len(b.Steps))
+		for _, step := range b.Steps {
+			s := *step
+			s.Output = ""
+			summary.Steps = append(summary.Steps, &s)
+		}
+		builds = append(builds, summary)
+	}
+	return builds
+}
+
+// BuildByID finds a build in the history, including step outputs.
+func (r *Runner) BuildByID(id int) (Build, bool) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	for _, b := range r.history {
+		if b.ID != id {
+			continue
+		}
+		build := *b
+		build.Steps = make([]*BuildStep, 0, len(b.Steps))
+		for _, step := range b.Steps {
+			s := *step
+			build.Steps = append(build.Steps, &s)
+		}
+		return build, true
+	}
+	return Build{}, false
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
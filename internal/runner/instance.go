@@ -0,0 +1,340 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errInstanceNotRunning is returned when trying to signal, restart or stop an
+// instance that has no running process.
+var errInstanceNotRunning = errors.New("instance not running")
+
+// errInstanceNotStopped is returned when trying to start an instance that was
+// not stopped.
+var errInstanceNotStopped = errors.New("instance not stopped")
+
+// Process instance states
+const (
+	StatePending = "pending"
+	StateWaiting = "waiting"
+	StateRunning = "running"
+	StateExited  = "exited"
+	StateFailed  = "failed"
+	StateStopped = "stopped"
+)
+
+// ProcessState is the observable state of an instance of a process type.
+type ProcessState struct {
+	Name        string    `json:"name"`
+	ProcessType string    `json:"processType"`
+	State       string    `json:"state"`
+	PID         int       `json:"pid,omitempty"`
+	Port        int       `json:"port,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	Restarts    int       `json:"restarts"`
+}
+
+// instance is one of the copies of a process type, as defined by the
+// formation.
+type instance struct {
+	name string
+
+	mu               sync.Mutex
+	cancel           context.CancelFunc
+	active           int // number of runInstance executing the instance
+	restartRequested bool
+	changedFileName  string
+	process          *os.Process
+	addr             string
+	state            string
+	startedAt        time.Time
+	runs             int
+	stopped          bool
+	startRequested   chan struct{}
+}
+
+func (r *Runner) instance(name string) *instance {
+	r.instancesMu.Lock()
+	defer r.instancesMu.Unlock()
+	inst, ok := r.instances[name]
+	if !ok {
+		inst = &instance{name: name, state: StatePending}
+		r.instances[name] = inst
+	}
+	return inst
+}
+
+// restart stops the current execution of the instance, which is then
+// started again by runInstance. Stopped instances are started. Instances
+// that runInstance is not executing cannot be restarted.
+func (i *instance) restart(changedFileName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.stopped {
+		i.startLocked()
+		return nil
+	}
+	if i.active == 0 {
+		return errInstanceNotRunning
+	}
+	i.restartRequested = true
+	i.changedFileName = changedFileName
+	if i.cancel != nil {
+		i.cancel()
+	}
+	return nil
+}
+
+// stop halts the current execution of the instance, which is not started
+// again until start or restart are called. Instances that did not start yet
+// can be stopped, but the ones that already exited cannot.
+func (i *instance) stop() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.stopped {
+		return nil
+	}
+	if i.active == 0 && i.state != StatePending {
+		return errInstanceNotRunning
+	}
+	i.stopped = true
+	i.startRequested = make(chan struct{})
+	if i.cancel != nil {
+		i.cancel()
+	}
+	return nil
+}
+
+// start resumes a stopped instance.
+func (i *instance) start() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.stopped {
+		return errInstanceNotStopped
+	}
+	i.startLocked()
+	return nil
+}
+
+func (i *instance) startLocked() {
+	if !i.stopped {
+		return
+	}
+	i.stopped = false
+	close(i.startRequested)
+}
+
+// waitStart blocks while the instance is stopped. It reports false if the
+// context is canceled in the meantime.
+func (i *instance) waitStart(ctx context.Context) bool {
+	i.mu.Lock()
+	stopped, startRequested := i.stopped, i.startRequested
+	i.mu.Unlock()
+	if !stopped {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-startRequested:
+		return true
+	}
+}
+
+func (i *instance) setCancel(cancel context.CancelFunc) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cancel = cancel
+}
+
+func (i *instance) setState(state string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.state = state
+}
+
+// running records that the process of the instance started, and the address
+// it is expected to listen on.
+func (i *instance) running(p *os.Process, addr string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.process = p
+	i.addr = addr
+	i.state = StateRunning
+	i.startedAt = time.Now()
+	i.runs++
+}
+
+// exited records that the process of the instance finished.
+func (i *instance) exited(ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.process = nil
+	switch {
+	case i.stopped:
+		i.state = StateStopped
+	case ok:
+		i.state = StateExited
+	default:
+		i.state = StateFailed
+	}
+}
+
+// runningAddr is the address the instance is expected to listen on, if it is
+// running.
+func (i *instance) runningAddr() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.process == nil {
+		return ""
+	}
+	return i.addr
+}
+
+func (i *instance) snapshot(procType string) ProcessState {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ps := ProcessState{
+		Name:        i.name,
+		ProcessType: procType,
+		State:       i.state,
+		Restarts:    max(i.runs-1, 0),
+	}
+	if i.stopped && i.process == nil {
+		ps.State = StateStopped
+	}
+	if i.process != nil {
+		ps.PID = i.process.Pid
+		ps.StartedAt = i.startedAt
+	}
+	if _, port, err := net.SplitHostPort(i.addr); err == nil {
+		ps.Port, _ = strconv.Atoi(port)
+	}
+	return ps
+}
+
+// signal delivers sig to the process group of the running process.
+func (i *instance) signal(sig syscall.Signal) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.process == nil {
+		return errInstanceNotRunning
+	}
+	return syscall.Kill(-i.process.Pid, sig)
+}
+
+// started reports how many times the process of the instance started, and the
+// address the last one is expected to listen on.
+func (i *instance) started() (int, string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.runs, i.addr
+}
+
+// activate records that runInstance executes the instance. Across rebuilds,
+// the runInstance of the new build may start before the one of the previous
+// build returns.
+func (i *instance) activate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.active++
+}
+
+func (i *instance) deactivate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.active--
+}
+
+// finished is called by runInstance when the process of the instance exits.
+// It reports whether the instance runs again, either because a restart was
+// requested, along with the changed file that caused it, or because it was
+// stopped and waits to be started. Otherwise, runInstance no longer executes
+// the instance.
+func (i *instance) finished() (changedFileName string, restart, again bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.restartRequested {
+		i.restartRequested = false
+		return i.changedFileName, true, true
+	}
+	if i.stopped {
+		return "", false, true
+	}
+	i.active--
+	return "", false, false
+}
+
+func (i *instance) takeRestart() (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	requested := i.restartRequested
+	i.restartRequested = false
+	return i.changedFileName, requested
+}
+
+// runInstance executes the process type as the instance procCount, starting
+// it again whenever the instance is asked to restart, and holding it while
+// it is stopped.
+func (r *Runner) runInstance(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string, buf io.Writer) bool {
+	inst := r.instance(instanceName(sv.Name, procCount))
+	inst.activate()
+	for {
+		if !inst.waitStart(ctx) {
+			inst.deactivate()
+			return true
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		inst.setCancel(cancel)
+		ok := r.startProcess(runCtx, sv, procCount, portCount, changedFileName, buf) == nil
+		cancel()
+		if ctx.Err() != nil {
+			inst.deactivate()
+			return ok
+		}
+		fn, restart, again := inst.finished()
+		if !again {
+			return ok
+		}
+		if restart {
+			changedFileName = fn
+		}
+	}
+}
+
+// processStates lists the state of all instances of the non-build process
+// types, in order of declaration.
+func (r *Runner) processStates() []ProcessState {
+	states := []ProcessState{}
+	for _, sv := range r.Processes {
+		if strings.HasPrefix(sv.Name, "build") {
+			continue
+		}
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			states = append(states, r.instance(instanceName(sv.Name, i)).snapshot(sv.Name))
+		}
+	}
+	return states
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed livereload.js
+var liveReloadJS []byte
+
+// Live reload kinds, carried in the detail of EventLiveReload.
+const (
+	liveReloadPage = "page"
+	liveReloadCSS  = "css"
+)
+
+// fireLiveReload waits for the instances of the restarted process types to
+// start again, since the ones they replace may still be listening, and for
+// their addresses to be reachable; and then tells the browsers to reload.
+// Runs are the number of times each instance had started before the restart,
+// see instanceRuns. When the change is a stylesheet, browsers only refresh
+// their stylesheets.
+func (r *Runner) fireLiveReload(ctx context.Context, changedFileName string, restarted []*ProcessType, runs map[string]int) {
+	for _, sv := range restarted {
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			name := instanceName(sv.Name, i)
+			addr, ok := r.waitStarted(ctx, name, runs[name])
+			if !ok {
+				return
+			}
+			if addr != "" {
+				r.waitFor(ctx, io.Discard, addr)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	kind := liveReloadPage
+	if strings.EqualFold(filepath.Ext(changedFileName), ".css") {
+		kind = liveReloadCSS
+	}
+	r.publish(EventLiveReload, changedFileName, kind)
+}
+
+// instanceRuns records how many times each instance started so far.
+func (r *Runner) instanceRuns() map[string]int {
+	r.instancesMu.Lock()
+	defer r.instancesMu.Unlock()
+	runs := make(map[string]int, len(r.instances))
+	for name, inst := range r.instances {
+		runs[name], _ = inst.started()
+	}
+	return runs
+}
+
+// waitStarted blocks until the named instance started more than runs times,
+// and returns the address it is expected to listen on. It reports false if
+// the context is canceled in the meantime.
+func (r *Runner) waitStarted(ctx context.Context, name string, runs int) (string, bool) {
+	inst := r.instance(name)
+	for {
+		if n, addr := inst.started(); n > runs {
+			return addr, true
+		}
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// injectLiveReload adds the live reload script to HTML responses proxied by
+// the built-in reverse proxy.
+func (r *Runner) injectLiveReload(resp *http.Response) error {
+	if !r.LiveReload || r.ServiceDiscoveryAddr == "" {
+		return nil
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read response body: %w", err)
+	}
+	resp.Body.Close()
+	snippet := []byte(`<script src="//` + r.liveReloadHost(resp.Request) + `/livereload.js"></script>`)
+	if m := closingBody.FindAllIndex(body, -1); len(m) > 0 {
+		body = slices.Insert(body, m[len(m)-1][0], snippet...)
+	} else {
+		body = append(body, snippet...)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+var closingBody = regexp.MustCompile(`(?i)</body>`)
+
+// liveReloadHost is the address browsers reach the service discovery server
+// on: the host they used for the proxied request, which the proxy forwards,
+// and the port of the service discovery server.
+func (r *Runner) liveReloadHost(req *http.Request) string {
+	host, port, err := net.SplitHostPort(r.ServiceDiscoveryAddr)
+	if err != nil {
+		return r.ServiceDiscoveryAddr
+	}
+	if req != nil && req.Host != "" {
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(req.Host, "[]")
+		}
+	} else if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (r *Runner) serveLiveReload(mux *http.ServeMux) {
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		if _, err := w.Write(liveReloadJS); err != nil {
+			log.Println("cannot serve live reload script:", err)
+		}
+	})
+	mux.HandleFunc("/livereload", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		r.streamEvents(w, req, EventLiveReload)
+	})
+}
@@ -0,0 +1,28 @@
+(function() {
+	var script = document.currentScript;
+	var base = script ? new URL(script.src).origin : "";
+	function refreshStylesheets() {
+		var links = document.querySelectorAll('link[rel="stylesheet"]');
+		for (var i = 0; i < links.length; i++) {
+			var u = new URL(links[i].href);
+			u.searchParams.set("livereload", Date.now());
+			links[i].href = u.toString();
+		}
+	}
+	function dial() {
+		var es = new EventSource(base + "/livereload");
+		es.onmessage = function(evt) {
+			var ev = JSON.parse(evt.data);
+			if (ev.detail === "css") {
+				refreshStylesheets();
+				return;
+			}
+			window.location.reload();
+		};
+		es.onerror = function() {
+			es.close();
+			setTimeout(dial, 1000);
+		};
+	}
+	dial();
+})();
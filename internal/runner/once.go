@@ -0,0 +1,274 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of steps in a one-shot run.
+const (
+	KindBuild   = "build"
+	KindService = "service"
+	KindTask    = "task"
+)
+
+// DefaultReadyTimeout is how long RunOnce waits for the services to be running
+// when Runner.ReadyTimeout is zero.
+const DefaultReadyTimeout = 2 * time.Minute
+
+// Report is the outcome of a one-shot run.
+type Report struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Steps    []StepResult  `json:"steps"`
+}
+
+// StepResult is the outcome of a build step, a service instance or a task
+// instance in a one-shot run.
+type StepResult struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"`
+	Status   string        `json:"status"`
+	ExitCode int           `json:"exitCode"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Output   string        `json:"output,omitempty"`
+}
+
+// Failed tells whether the step did not succeed.
+func (s StepResult) Failed() bool {
+	switch s.Status {
+	case BuildDone, BuildCached, StateRunning, StateExited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Failed tells whether any of the steps of the run did not succeed.
+func (rep *Report) Failed() bool {
+	for _, s := range rep.Steps {
+		if s.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// isTask tells whether the process type runs to completion in one-shot runs:
+// the ones prefixed with "test" or "check".
+func isTask(sv *ProcessType) bool {
+	return strings.HasPrefix(sv.Name, "test") || strings.HasPrefix(sv.Name, "check")
+}
+
+// isService tells whether the process type is started alongside the others
+// after the builds. In one-shot runs, tasks are executed separately.
+func (r *Runner) isService(sv *ProcessType) bool {
+	if strings.HasPrefix(sv.Name, "build") {
+		return false
+	}
+	return !r.once || !isTask(sv)
+}
+
+// RunOnce executes the application a single time, as in a CI pipeline: it runs
+// the build steps, starts the long-running process types, waits for them to
+// be running, runs the tasks (process types prefixed with "test" or "check")
+// to completion, in order of declaration, and tears everything down. Tasks
+// that must wait for a service to accept connections should use waitfor.
+func (r *Runner) RunOnce(ctx context.Context) (*Report, error) {
+	r.once = true
+	if err := r.prepare(ctx); err != nil {
+		return nil, err
+	}
+	defer r.closeSockets()
+	rep := &Report{Start: time.Now()}
+	defer func() {
+		rep.Duration = time.Since(rep.Start)
+	}()
+	var digest string
+	if r.BuildCache {
+		digest = r.inputsDigest()
+	}
+	buildsOK := r.runBuilds(ctx, "", digest)
+	if builds := r.Builds(); len(builds) > 0 {
+		build, _ := r.BuildByID(builds[len(builds)-1].ID)
+		for _, step := range build.Steps {
+			rep.Steps = append(rep.Steps, StepResult{
+				Name:     step.Name,
+				Kind:     KindBuild,
+				Status:   step.Status,
+				ExitCode: step.ExitCode,
+				Start:    step.Start,
+				Duration: step.Duration,
+				Output:   step.Output,
+			})
+		}
+	}
+	var tasks []*ProcessType
+	for _, sv := range r.Processes {
+		if !strings.HasPrefix(sv.Name, "build") && isTask(sv) && r.Formation[sv.Name] > 0 {
+			tasks = append(tasks, sv)
+		}
+	}
+	if !buildsOK {
+		log.Println("error during build, skipping tasks")
+		r.skipTasks(rep, tasks)
+		return rep, nil
+	}
+
+	servicesCtx, stopServices := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	tree, _ := r.runPermanent("")
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		_ = tree.Start(servicesCtx)
+	}()
+	go func() {
+		defer wg.Done()
+		r.runEphemeral(servicesCtx, "")
+	}()
+	go func() {
+		defer wg.Done()
+		r.runConsumers(servicesCtx, "")
+	}()
+	ready := r.waitServices(ctx)
+	if !ready {
+		log.Println("services not ready, skipping tasks")
+		r.skipTasks(rep, tasks)
+	} else {
+		for j, sv := range r.Processes {
+			if !slices.Contains(tasks, sv) {
+				continue
+			}
+			for i := 0; i < r.Formation[sv.Name]; i++ {
+				rep.Steps = append(rep.Steps, r.runTask(ctx, sv, i, j*100+i))
+			}
+		}
+	}
+	services := r.serviceResults()
+	stopServices()
+	wg.Wait()
+	rep.Steps = append(rep.Steps, services...)
+	return rep, nil
+}
+
+// waitServices waits until no service instance is pending or waiting for its
+// dependencies, and reports whether none of them failed. It gives up after
+// ReadyTimeout.
+func (r *Runner) waitServices(ctx context.Context) bool {
+	timeout := r.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		settled := true
+		for _, ps := range r.serviceInstances() {
+			switch ps.State {
+			case StateFailed:
+				log.Println("service failed before the tasks started:", ps.Name)
+				return false
+			case StatePending, StateWaiting:
+				settled = false
+			}
+		}
+		if settled {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("services not ready after", timeout)
+			return false
+		case <-t.C:
+		}
+	}
+}
+
+// serviceInstances lists the state of the instances of the services.
+func (r *Runner) serviceInstances() []ProcessState {
+	var states []ProcessState
+	for _, sv := range r.Processes {
+		if !r.isService(sv) {
+			continue
+		}
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			states = append(states, r.instance(instanceName(sv.Name, i)).snapshot(sv.Name))
+		}
+	}
+	return states
+}
+
+func (r *Runner) serviceResults() []StepResult {
+	var results []StepResult
+	for _, ps := range r.serviceInstances() {
+		res := StepResult{
+			Name:   ps.Name,
+			Kind:   KindService,
+			Status: ps.State,
+			Start:  ps.StartedAt,
+		}
+		if !ps.StartedAt.IsZero() {
+			res.Duration = time.Since(ps.StartedAt)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// runTask executes one instance of a task to completion.
+func (r *Runner) runTask(ctx context.Context, sv *ProcessType, procCount, portCount int) StepResult {
+	res := StepResult{
+		Name:  instanceName(sv.Name, procCount),
+		Kind:  KindTask,
+		Start: time.Now(),
+	}
+	var buf bytes.Buffer
+	err := r.startProcess(ctx, sv, procCount, portCount, "", &buf)
+	res.Duration = time.Since(res.Start)
+	res.ExitCode = exitCode(err)
+	res.Status = BuildDone
+	if err != nil {
+		res.Status = BuildErrored
+	}
+	res.Output = buf.String()
+	if len(res.Output) > maxBuildStepOutput {
+		res.Output = res.Output[len(res.Output)-maxBuildStepOutput:]
+	}
+	r.publish(EventTask, res.Name, res.Status)
+	return res
+}
+
+func (r *Runner) skipTasks(rep *Report, tasks []*ProcessType) {
+	for _, sv := range tasks {
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			rep.Steps = append(rep.Steps, StepResult{
+				Name:   instanceName(sv.Name, i),
+				Kind:   KindTask,
+				Status: BuildSkipped,
+			})
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProxyHold is how long the proxy holds a request while no instance of
+// the target process type is available, for instance during a rebuild.
+const DefaultProxyHold = 30 * time.Second
+
+// Proxy configures the built-in HTTP reverse proxy, which routes requests to
+// process types and round-robins across their running instances.
+type Proxy struct {
+	// Addr is the net.Listen address of the proxy.
+	Addr string
+
+	// Hold is how long a request waits for an instance of the target
+	// process type to accept connections before failing. If zero,
+	// DefaultProxyHold is used.
+	Hold time.Duration
+
+	// Routes are evaluated by specificity: routes with a host take
+	// precedence over routes without one, and longer path prefixes take
+	// precedence over shorter ones.
+	Routes []ProxyRoute
+}
+
+// ProxyRoute maps requests, by host and path prefix, to a process type.
+type ProxyRoute struct {
+	Host        string `json:"host,omitempty"`
+	PathPrefix  string `json:"pathPrefix,omitempty"`
+	ProcessType string `json:"processType"`
+}
+
+// route finds the most specific route for the request.
+func (p *Proxy) route(req *http.Request) (ProxyRoute, bool) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var (
+		best      ProxyRoute
+		bestScore = -1
+	)
+	for _, route := range p.Routes {
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if !hasPathPrefix(req.URL.Path, route.PathPrefix) {
+			continue
+		}
+		score := len(route.PathPrefix)
+		if route.Host != "" {
+			score += 1 << 16
+		}
+		if score > bestScore {
+			best, bestScore = route, score
+		}
+	}
+	return best, bestScore > -1
+}
+
+// hasPathPrefix tells whether the path is the prefix, or is below it: /api
+// matches /api and /api/v1, but not /apix.
+func hasPathPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(prefix, "/"))
+}
+
+type proxyTargetKey struct{}
+
+// proxyTarget carries the process type chosen by the router down to the
+// dialer, and the instance picked by the dialer up to the access log.
+type proxyTarget struct {
+	processType string
+	instance    atomic.Value
+}
+
+func (r *Runner) serveProxy(ctx context.Context) error {
+	p := r.Proxy
+	if p == nil || p.Addr == "" {
+		return nil
+	}
+	hold := p.Hold
+	if hold <= 0 {
+		hold = DefaultProxyHold
+	}
+	l, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	log.Println("starting proxy on", l.Addr())
+	var roundRobin atomic.Uint64
+	dialer := &net.Dialer{Timeout: time.Second}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetXForwarded()
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = "runner-proxy-target"
+			pr.Out.Host = pr.In.Host
+		},
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				target, _ := ctx.Value(proxyTargetKey{}).(*proxyTarget)
+				if target == nil {
+					return nil, errors.New("missing proxy target")
+				}
+				deadline := time.Now().Add(hold)
+				for {
+					addrs := r.runningAddrs(target.processType)
+					if len(addrs) > 0 {
+						n := roundRobin.Add(1)
+						for i := range addrs {
+							addr := addrs[(int(n)+i)%len(addrs)]
+							conn, err := dialer.DialContext(ctx, network, addr)
+							if err == nil {
+								target.instance.Store(addr)
+								return conn, nil
+							}
+						}
+					}
+					if time.Now().After(deadline) {
+						return nil, fmt.Errorf("no instance of %s available after %v", target.processType, hold)
+					}
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-time.After(100 * time.Millisecond):
+					}
+				}
+			},
+		},
+		ModifyResponse: r.injectLiveReload,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if req.Context().Err() != nil {
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		route, ok := p.route(req)
+		if !ok {
+			http.NotFound(w, req)
+			r.logLine("proxy", fmt.Sprintf("%s %s %d %v (no route)", req.Method, req.URL.RequestURI(), http.StatusNotFound, time.Since(start)))
+			return
+		}
+		target := &proxyTarget{processType: route.ProcessType}
+		req = req.WithContext(context.WithValue(req.Context(), proxyTargetKey{}, target))
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		rp.ServeHTTP(sw, req)
+		upstream, _ := target.instance.Load().(string)
+		r.logLine("proxy", fmt.Sprintf("%s %s %d %v -> %s %s", req.Method, req.URL.RequestURI(), sw.status, time.Since(start).Round(time.Millisecond), route.ProcessType, upstream))
+	})
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("proxy server failed:", err)
+		}
+	}()
+	return nil
+}
+
+// runningAddrs lists the addresses of the running instances of a process
+// type.
+func (r *Runner) runningAddrs(procType string) []string {
+	var addrs []string
+	for i := 0; i < r.Formation[procType]; i++ {
+		if addr := r.instance(instanceName(procType, i)).runningAddr(); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ParseSignal takes a signal name, with or without the SIG prefix, or its
+// number and converts to syscall.Signal. If the parsing fails, it returns
+// zero.
+func ParseSignal(s string) syscall.Signal {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n)
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	switch name {
+	case "HUP":
+		return syscall.SIGHUP
+	case "INT":
+		return syscall.SIGINT
+	case "QUIT":
+		return syscall.SIGQUIT
+	case "KILL":
+		return syscall.SIGKILL
+	case "USR1":
+		return syscall.SIGUSR1
+	case "USR2":
+		return syscall.SIGUSR2
+	case "TERM":
+		return syscall.SIGTERM
+	case "CONT":
+		return syscall.SIGCONT
+	case "WINCH":
+		return syscall.SIGWINCH
+	default:
+		return 0
+	}
+}
+
+// watchPatterns are all the file patterns that the workdir monitor must
+// observe: the ones that trigger builds and the ones that trigger reloads.
+func (r *Runner) watchPatterns() []string {
+	patterns := slices.Clone(r.Observables)
+	for _, sv := range r.Processes {
+		if sv.Reload == 0 {
+			continue
+		}
+		patterns = append(patterns, sv.ReloadOn...)
+	}
+	return patterns
+}
+
+// reload delivers the reload signal to the process groups of all instances
+// of the process types whose reload-on patterns match the changed file. It
+// reports whether the change was handled by a reload, in which case the
+// runner skips builds and restarts. Changes to files that the observed
+// patterns also match are not handled by reloads alone.
+func (r *Runner) reload(changedFileName string) bool {
+	var handled bool
+	for _, sv := range r.Processes {
+		if sv.Reload == 0 || !matchAny(sv.ReloadOn, changedFileName) {
+			continue
+		}
+		handled = true
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			name := instanceName(sv.Name, i)
+			if err := r.instance(name).signal(sv.Reload); err != nil {
+				log.Printf("cannot reload %s: %v", name, err)
+				continue
+			}
+			log.Printf("reloaded %s with %v (%s changed)", name, sv.Reload, changedFileName)
+			r.publish(EventReload, name, fmt.Sprintf("%v delivered, %s changed", sv.Reload, changedFileName))
+		}
+	}
+	return handled && !r.isInput(changedFileName)
+}
+
+func matchAny(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if match(p, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxReportOutput bounds the output of each step written in reports. Only the
+// tail is preserved, as that is where failures usually are.
+const maxReportOutput = 8 * 1024
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Detail  string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report in the JUnit XML format. Each step is a test
+// case, grouped in test suites by kind. The output of failed steps is the
+// failure detail.
+func (rep *Report) WriteJUnit(w io.Writer) error {
+	doc := junitTestSuites{Time: junitSeconds(rep.Duration)}
+	suites := make(map[string]int)
+	for _, step := range rep.Steps {
+		idx, ok := suites[step.Kind]
+		if !ok {
+			idx = len(doc.Suites)
+			suites[step.Kind] = idx
+			doc.Suites = append(doc.Suites, junitTestSuite{
+				Name:      step.Kind,
+				Timestamp: rep.Start.Format(time.RFC3339),
+			})
+		}
+		suite := &doc.Suites[idx]
+		tc := junitTestCase{
+			Name:      step.Name,
+			ClassName: step.Kind,
+			Time:      junitSeconds(step.Duration),
+		}
+		output := trimOutput(step.Output)
+		switch {
+		case step.Status == BuildSkipped:
+			tc.Skipped = &junitSkipped{Message: "skipped"}
+			suite.Skipped++
+		case step.Failed():
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s with exit code %d", step.Status, step.ExitCode),
+				Type:    step.Status,
+				Detail:  output,
+			}
+			suite.Failures++
+		default:
+			tc.SystemOut = output
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for i := range doc.Suites {
+		var d time.Duration
+		for _, step := range rep.Steps {
+			if step.Kind == doc.Suites[i].Name {
+				d += step.Duration
+			}
+		}
+		doc.Suites[i].Time = junitSeconds(d)
+		doc.Tests += doc.Suites[i].Tests
+		doc.Failures += doc.Suites[i].Failures
+		doc.Skipped += doc.Suites[i].Skipped
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON writes the report in JSON, with the same trimmed outputs of the
+// JUnit report.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	trimmed := *rep
+	trimmed.Steps = make([]StepResult, len(rep.Steps))
+	for i, step := range rep.Steps {
+		step.Output = trimOutput(step.Output)
+		trimmed.Steps[i] = step
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(trimmed)
+}
+
+func trimOutput(output string) string {
+	if len(output) <= maxReportOutput {
+		return output
+	}
+	return "...\n" + output[len(output)-maxReportOutput:]
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	rep := &Report{
+		Start:    time.Now(),
+		Duration: 3 * time.Second,
+		Steps: []StepResult{
+			{Name: "build", Kind: KindBuild, Status: BuildDone, Duration: time.Second, Output: "built"},
+			{Name: "check.0", Kind: KindTask, Status: BuildErrored, ExitCode: 3, Duration: time.Second, Output: strings.Repeat("x", 2*maxReportOutput) + "<failed>"},
+			{Name: "test.0", Kind: KindTask, Status: BuildSkipped},
+		},
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JUnit report: %v\n%s", err, buf.String())
+	}
+	if doc.Tests != 3 || doc.Failures != 1 || doc.Skipped != 1 || len(doc.Suites) != 2 {
+		t.Errorf("unexpected totals: %+v", doc)
+	}
+	check := doc.Suites[1].Cases[0]
+	if check.Failure == nil || check.Failure.Message != "errored with exit code 3" {
+		t.Fatalf("failed steps must be failures: %+v", check)
+	}
+	if len(check.Failure.Detail) > maxReportOutput+10 || !strings.HasSuffix(check.Failure.Detail, "<failed>") {
+		t.Errorf("failure detail must be the trimmed tail of the output, got %d bytes", len(check.Failure.Detail))
+	}
+	if doc.Suites[1].Cases[1].Skipped == nil {
+		t.Error("skipped steps must be skipped")
+	}
+
+	buf.Reset()
+	if err := rep.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Steps) != 3 || decoded.Steps[1].ExitCode != 3 || len(decoded.Steps[1].Output) > maxReportOutput+10 {
+		t.Errorf("unexpected JSON report: %+v", decoded.Steps)
+	}
+	if len(rep.Steps[1].Output) <= maxReportOutput {
+		t.Error("writing reports must not change the report")
+	}
+}
@@ -18,6 +18,7 @@ package runner
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -35,6 +36,7 @@ import (
 	"time"
 
 	"cirello.io/oversight"
+	"cirello.io/runner/v3/internal/shell"
 )
 
 // ErrNonUniqueProcessTypeName is returned when starting the runner, it detects
@@ -42,23 +44,36 @@ import (
 // normalizes them.
 var ErrNonUniqueProcessTypeName = errors.New("non unique process type name")
 
+// ErrInvalidBuildOrder is returned when starting the runner, it detects that
+// build steps run after unknown build steps or after each other in a cycle.
+var ErrInvalidBuildOrder = errors.New("invalid build order")
+
 // RestartMode defines if a process should restart itself.
 type RestartMode string
 
 // ParseRestartMode takes a string and converts to RestartMode. If the parsing
 // fails, it silently defaults to Never.
 func ParseRestartMode(m string) RestartMode {
+	mode, _ := LookupRestartMode(m)
+	return mode
+}
+
+// LookupRestartMode takes a string and converts to RestartMode, reporting
+// whether the string is a known restart mode.
+func LookupRestartMode(m string) (RestartMode, bool) {
 	switch strings.ToLower(m) {
 	case "onbuild", "yes", "always", "true", "1", "build":
-		return OnBuild
+		return OnBuild, true
 	case "fail", "failure", "onfail", "onfailure", "on-failure", "on_failure":
-		return OnFailure
+		return OnFailure, true
 	case "temporary", "start-once", "temp", "tmp":
-		return Temporary
+		return Temporary, true
 	case "loop":
-		return Loop
+		return Loop, true
+	case "", "no", "never", "false", "0":
+		return Never, true
 	default:
-		return Never
+		return Never, false
 	}
 }
 
@@ -100,8 +115,85 @@ type ProcessType struct {
 	// - temporary|tmp: start the process once and skip restart on rebuild.
 	// Temporary processes do not show up in the discovery service.
 	Restart RestartMode `json:"restart,omitempty"`
+
+	// Outputs are the files, relative to the working directory, produced
+	// by a build step. After each successful build, the runner hashes them
+	// to find out which artifacts actually changed.
+	Outputs []string `json:"outputs,omitempty"`
+
+	// Consumes are the build outputs this process type depends on. Process
+	// types that declare them are only restarted after a build if one of
+	// their consumed outputs changed; otherwise they keep running.
+	Consumes []string `json:"consumes,omitempty"`
+
+	// Reload is the signal delivered to the process group of the process
+	// type, instead of a restart, when only files matching ReloadOn
+	// change.
+	Reload syscall.Signal `json:"reload,omitempty"`
+
+	// ReloadOn are the filepath.Match() patterns of the files that, upon
+	// change, trigger a reload rather than a build.
+	ReloadOn []string `json:"reloadOn,omitempty"`
+
+	// Socket makes the runner own a listening socket for each instance of
+	// the process type, which is passed to the process following the
+	// systemd socket activation protocol (LISTEN_FDS, LISTEN_PID and file
+	// descriptor 3). The socket stays open across restarts, so incoming
+	// connections queue instead of being refused.
+	//
+	// - tcp: listen on an ephemeral port on localhost.
+	// - tcp:port|tcp:host:port: listen on the given address, the port is
+	// offset by the instance number.
+	Socket string `json:"socket,omitempty"`
+
+	// After are the build steps that must succeed before this build step
+	// starts. If any of them fails, this build step is skipped.
+	After []string `json:"after,omitempty"`
+
+	// Signal is delivered to the process group of the process type to stop
+	// it. Zero means SIGTERM.
+	Signal syscall.Signal `json:"signal,omitempty"`
+
+	// Timeout is the maximum duration of a build step. When it expires, the
+	// process group of the step is killed and the step is marked as timed
+	// out. For other process types, it is how long the runner waits after
+	// sending Signal before killing the process group. Zero means no limit.
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Retries is the number of times a failed build step is executed again
+	// before the build is considered failed, waiting RetryDelay between
+	// attempts.
+	Retries int `json:"retries,omitempty"`
+
+	// RetryDelay is the pause between attempts of a failed build step. If
+	// zero, DefaultRetryDelay is used.
+	RetryDelay time.Duration `json:"retryDelay,omitempty"`
+
+	// Groups are the names of the groups the process type belongs to. They
+	// select the process type as @group in profiles and in the Selection.
+	Groups []string `json:"groups,omitempty"`
+
+	// Env lists KEY=VALUE variables set for the process type only. They
+	// take precedence over EnvFiles, see Runner.Environment.
+	Env []string `json:"-"`
+
+	// EnvFiles are environment files, relative to the working directory,
+	// read every time the process type starts.
+	EnvFiles []string `json:"envFiles,omitempty"`
+
+	// Dir is the directory, relative to the working directory, in which
+	// the process type runs. Empty means the working directory.
+	Dir string `json:"dir,omitempty"`
 }
 
+// DefaultRetryDelay is the pause between attempts of a failed build step when
+// ProcessType.RetryDelay is zero.
+const DefaultRetryDelay = time.Second
+
+// errStepTimedOut is the cancellation cause of build steps that run longer
+// than their timeout.
+var errStepTimedOut = errors.New("build step timed out")
+
 // Runner defines how this application should be started.
 type Runner struct {
 	// WorkDir is the working directory from which all commands are going
@@ -131,6 +223,10 @@ type Runner struct {
 	// the current environment.
 	SkipProcs []string
 
+	// Profiles are named formations that Select can apply. Their keys are
+	// process type names, or group names prefixed by @.
+	Profiles map[string]map[string]int
+
 	// BaseEnvironment is the set of environment variables loaded into
 	// the service.
 	BaseEnvironment []string
@@ -143,12 +239,79 @@ type Runner struct {
 	// variable named "DISCOVERY".
 	ServiceDiscoveryAddr string
 
+	// BasePort is the first port assigned to process instances through the
+	// environment variable named "PORT". Each instance gets its own port,
+	// unless BaseEnvironment or the options of its process type set PORT.
+	// Set to zero to not assign ports.
+	BasePort int
+
+	// Proxy configures the built-in HTTP reverse proxy. Set to nil to
+	// disable it.
+	Proxy *Proxy
+
+	// LiveReload makes the built-in reverse proxy inject the live reload
+	// script into HTML responses. The script reloads the page once builds
+	// succeed and the restarted processes are reachable again.
+	LiveReload bool
+
+	// BuildJobs is the maximum number of build steps executed at the same
+	// time. Zero or one execute them sequentially, in order of declaration,
+	// and a failure skips all the remaining steps. With more jobs, steps
+	// only wait for the ones they declare in After.
+	BuildJobs int
+
+	// ReadyTimeout bounds how long RunOnce waits for the services to be
+	// running before it gives up and skips the tasks. If zero,
+	// DefaultReadyTimeout is used.
+	ReadyTimeout time.Duration
+
+	// BuildHistorySize is the number of builds kept in the history. If
+	// zero, DefaultBuildHistorySize is used.
+	BuildHistorySize int
+
+	// EditorURL is the template of the links that open the diagnostics of
+	// failed builds in an editor. {path} is replaced by the absolute path
+	// of the file, {file} by its path relative to WorkDir, {line} and
+	// {col} by the position. Example: vscode://file{path}:{line}:{col}
+	EditorURL string
+
+	// BuildCache enables the content-hash build cache. Build steps whose
+	// observed input files, command and environment are identical to
+	// their last successful run are skipped, and so is the restart that
+	// would follow them, unless their Outputs are missing or changed. The
+	// cache is persisted in the ".runner" directory inside WorkDir, so it
+	// survives runner restarts.
+	BuildCache bool
+	buildCache *buildCache
+
+	outputHashes map[string]string // map of build output and its hash
+
 	servicesMu    sync.Mutex
 	serviceStates map[string]string // map of service name and state
 
+	instancesMu sync.Mutex
+	instances   map[string]*instance // map of instance name and its controls
+
+	socketsMu sync.Mutex
+	sockets   map[string]*socket // map of instance name and its listener
+
 	logsMu         sync.RWMutex
 	logs           chan LogMessage
 	logSubscribers []chan LogMessage
+
+	eventsMu         sync.RWMutex
+	eventSubscribers []chan Event
+
+	diagnosticsMu sync.Mutex
+	diagnostics   map[string][]BuildDiagnostic // map of build step and its diagnostics
+
+	once bool // set by RunOnce, tasks are not started as services
+
+	historyMu       sync.Mutex
+	history         []*Build
+	buildGeneration int
+
+	selection *Selection // set by Select, before Start
 }
 
 // LogMessage broadcasted through websocket.
@@ -163,43 +326,23 @@ func New() *Runner {
 	return &Runner{
 		Formation:     make(map[string]int),
 		serviceStates: make(map[string]string),
+		instances:     make(map[string]*instance),
+		sockets:       make(map[string]*socket),
+		diagnostics:   make(map[string][]BuildDiagnostic),
+		outputHashes:  make(map[string]string),
 		logs:          make(chan LogMessage, sseLogForwarderBufferSize),
 	}
 }
 
 // Start initiates the application.
 func (r *Runner) Start(rootCtx context.Context) error {
-	slices.SortStableFunc(r.Observables, func(a, b string) int {
-		negateA := len(a) > 0 && a[0] == '!'
-		negateB := len(b) > 0 && b[0] == '!'
-		switch {
-		case negateA && !negateB:
-			return -1
-		case !negateA && negateB:
-			return 1
-		default:
-			return 0
-		}
-	})
-	nameDict := make(map[string]struct{})
-	for _, proc := range r.Processes {
-		name := fmt.Sprintf("%v.%v", proc.Name, r.Formation[proc.Name])
-		if _, ok := nameDict[normalizeByEnvVarRules(name)]; ok {
-			return ErrNonUniqueProcessTypeName
-		}
-		nameDict[normalizeByEnvVarRules(name)] = struct{}{}
-		if l := len(name); l > r.longestProcessTypeName {
-			r.longestProcessTypeName = l
-		}
-	}
-	r.longestProcessTypeName++
-	if err := r.serveWeb(rootCtx); err != nil {
-		return fmt.Errorf("cannot serve discovery interface: %w", err)
+	if err := r.prepare(rootCtx); err != nil {
+		return err
 	}
-	r.forwardLogs()
 	var (
-		runCancel context.CancelFunc = func() {}
-		wg        sync.WaitGroup
+		runCancel  context.CancelFunc = func() {}
+		wg         sync.WaitGroup
+		lastDigest string
 	)
 	ephemeralOnce := sync.OnceFunc(func() {
 		wg.Add(1)
@@ -208,79 +351,349 @@ func (r *Runner) Start(rootCtx context.Context) error {
 			r.runEphemeral(rootCtx, "")
 		}()
 	})
+	consumersStarted := false
 	updates := r.monitorWorkDir(rootCtx)
 	for {
 		select {
 		case <-rootCtx.Done():
 			runCancel()
 			wg.Wait()
+			r.closeSockets()
 			return nil
 		case fn := <-updates:
+			if fn != "" && r.reload(fn) {
+				continue
+			}
+			var digest string
+			if r.BuildCache {
+				digest = r.inputsDigest()
+				if fn != "" && digest == lastDigest {
+					log.Println("inputs unchanged, skipping build and restart:", fn)
+					continue
+				}
+			}
 			runCancel()
 			ctx, cancel := context.WithCancel(rootCtx)
 			runCancel = cancel
-			if ok := r.runBuilds(ctx, fn); !ok {
+			if ok := r.runBuilds(ctx, fn, digest); !ok {
 				log.Println("error during build, halted")
 				continue
 			}
+			lastDigest = digest
 			ephemeralOnce()
-			tree := r.runPermanent(fn)
+			changedOutputs := r.hashOutputs()
+			runs := r.instanceRuns()
+			var restarted []*ProcessType
+			if !consumersStarted {
+				consumersStarted = true
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r.runConsumers(rootCtx, fn)
+				}()
+			} else {
+				restarted = r.restartConsumers(changedOutputs, fn)
+			}
+			tree, permanent := r.runPermanent(fn)
+			restarted = append(restarted, permanent...)
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				_ = tree.Start(ctx)
 			}()
+			if fn != "" {
+				go r.fireLiveReload(ctx, fn, restarted, runs)
+			}
+		}
+	}
+}
+
+// prepare validates the configuration and starts the auxiliary services:
+// service discovery, log forwarding and the reverse proxy.
+func (r *Runner) prepare(ctx context.Context) error {
+	r.logSelection()
+	slices.SortStableFunc(r.Observables, func(a, b string) int {
+		negateA := len(a) > 0 && a[0] == '!'
+		negateB := len(b) > 0 && b[0] == '!'
+		switch {
+		case negateA && !negateB:
+			return -1
+		case !negateA && negateB:
+			return 1
+		default:
+			return 0
+		}
+	})
+	nameDict := make(map[string]struct{})
+	for _, proc := range r.Processes {
+		name := fmt.Sprintf("%v.%v", proc.Name, r.Formation[proc.Name])
+		if _, ok := nameDict[normalizeByEnvVarRules(name)]; ok {
+			return ErrNonUniqueProcessTypeName
+		}
+		nameDict[normalizeByEnvVarRules(name)] = struct{}{}
+		if l := len(name); l > r.longestProcessTypeName {
+			r.longestProcessTypeName = l
 		}
 	}
+	if err := r.validateBuildOrder(); err != nil {
+		return err
+	}
+	if r.Proxy != nil && len("proxy") > r.longestProcessTypeName {
+		r.longestProcessTypeName = len("proxy")
+	}
+	r.longestProcessTypeName++
+	if err := r.serveWeb(ctx); err != nil {
+		return fmt.Errorf("cannot serve discovery interface: %w", err)
+	}
+	r.forwardLogs()
+	if err := r.serveProxy(ctx); err != nil {
+		return fmt.Errorf("cannot serve proxy: %w", err)
+	}
+	if r.BuildCache {
+		r.buildCache = loadBuildCache(filepath.Join(r.WorkDir, stateDirName))
+	}
+	return nil
 }
 
-func (r *Runner) runBuilds(ctx context.Context, fn string) bool {
+// runBuilds executes the build steps. At most BuildJobs steps run at once,
+// and a step only starts when the steps it runs after are done. In sequential
+// mode, the steps run in order of declaration and a failure skips all the
+// remaining ones; otherwise, a failure only skips the steps that depend on the
+// failed one.
+func (r *Runner) runBuilds(ctx context.Context, fn, inputsDigest string) bool {
+	build := r.newBuild(fn)
+	ok := true
+	defer func() {
+		r.finishBuild(build, ok)
+	}()
+	type result struct {
+		name string
+		ok   bool
+	}
 	var (
-		wgBuild sync.WaitGroup
-		mu      sync.Mutex
-		ok      = true
+		pending  []*ProcessType
+		copies   = make(map[string]int)    // map of build step and its running copies
+		launched = make(map[string]int)    // map of build step and its started copies
+		statuses = make(map[string]string) // map of build step and its final status
+		results  = make(chan result)
+		running  int
 	)
 	for _, sv := range r.Processes {
-		if !strings.HasPrefix(sv.Name, "build") {
-			continue
+		if strings.HasPrefix(sv.Name, "build") && r.Formation[sv.Name] > 0 {
+			pending = append(pending, sv)
 		}
-		maxProc := r.Formation[sv.Name]
-		for i := 0; i < maxProc; i++ {
-			r.setServiceState(normalizeByEnvVarRules(sv.Name), "building")
-			wgBuild.Add(1)
-			go func(sv *ProcessType) {
-				var buf bytes.Buffer
-				localOk := true
-				defer wgBuild.Done()
-				defer func() {
-					status := "done"
-					if !localOk {
-						status = "errored"
-						r.setServiceState("ERROR_"+normalizeByEnvVarRules(sv.Name), buf.String())
-					} else {
-						r.deleteServiceState("ERROR_" + normalizeByEnvVarRules(sv.Name))
-					}
-					r.setServiceState(normalizeByEnvVarRules(sv.Name), status)
-				}()
-				if !r.startProcess(ctx, sv, -1, -1, fn, &buf) {
-					mu.Lock()
-					ok = false
-					localOk = false
-					mu.Unlock()
+	}
+	jobs := max(r.BuildJobs, 1)
+	sequential := jobs == 1
+	for len(pending) > 0 || running > 0 {
+		var blocked []*ProcessType
+		for _, sv := range pending {
+			ready, failedDep := true, ""
+			for _, dep := range sv.After {
+				status, finished := statuses[dep]
+				switch {
+				case status == BuildErrored || status == BuildSkipped:
+					failedDep = dep
+				case !finished && (copies[dep] > 0 || slices.ContainsFunc(pending, func(p *ProcessType) bool { return p.Name == dep })):
+					ready = false
+				}
+			}
+			if statuses[sv.Name] == BuildErrored {
+				// a copy of this step failed: drop the copies
+				// that have not started yet.
+				continue
+			}
+			if failedDep != "" || (sequential && !ok) {
+				if failedDep == "" {
+					failedDep = "a previous step"
+				}
+				log.Println("skipping build step", sv.Name, "because", failedDep, "failed")
+				r.skipBuildStep(build, sv)
+				statuses[sv.Name] = BuildSkipped
+				continue
+			}
+			if !ready || running >= jobs {
+				blocked = append(blocked, sv)
+				continue
+			}
+			if r.buildCache != nil && launched[sv.Name] == 0 {
+				if r.buildCache.hit(sv.Name, r.buildStepKey(sv, inputsDigest)) {
+					log.Println("build step unchanged, skipping:", sv.Name)
+					step := r.addStep(build, sv.Name, 0)
+					r.finishStep(step, BuildCached, nil, "")
+					r.setServiceState(normalizeByEnvVarRules(sv.Name), BuildCached)
+					r.publish(EventBuild, sv.Name, BuildCached)
+					statuses[sv.Name] = BuildCached
+					continue
 				}
-			}(sv)
+			}
+			// each copy of the step takes a job, and the copies
+			// that do not fit wait for the next round.
+			n := min(r.Formation[sv.Name]-launched[sv.Name], jobs-running)
+			copies[sv.Name] += n
+			launched[sv.Name] += n
+			running += n
+			for i := 0; i < n; i++ {
+				go func(sv *ProcessType) {
+					results <- result{sv.Name, r.runBuildStep(ctx, build, sv, fn, inputsDigest)}
+				}(sv)
+			}
+			if launched[sv.Name] < r.Formation[sv.Name] {
+				blocked = append(blocked, sv)
+			}
+		}
+		pending = blocked
+		if running == 0 {
+			if len(pending) > 0 {
+				// unreachable when the build order is valid, but
+				// avoids a deadlock otherwise.
+				for _, sv := range pending {
+					r.skipBuildStep(build, sv)
+				}
+				ok = false
+			}
+			break
+		}
+		res := <-results
+		running--
+		copies[res.name]--
+		if !res.ok {
+			ok = false
+			statuses[res.name] = BuildErrored
+		}
+		if copies[res.name] == 0 && launched[res.name] == r.Formation[res.name] {
+			if _, failed := statuses[res.name]; !failed {
+				statuses[res.name] = BuildDone
+			}
 		}
 	}
-	wgBuild.Wait()
 	return ok
 }
 
-func (r *Runner) runPermanent(changedFileName string) *oversight.Tree {
+// runBuildStep executes one copy of a build step, retrying it if it fails, and
+// records the outcome of each attempt.
+func (r *Runner) runBuildStep(ctx context.Context, build *Build, sv *ProcessType, fn, inputsDigest string) bool {
+	delay := sv.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	attempts := max(sv.Retries, 0) + 1
+	for attempt := 1; ; attempt++ {
+		status := r.runBuildAttempt(ctx, build, sv, fn, inputsDigest, attempt)
+		if status == BuildDone {
+			return true
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			return false
+		}
+		r.logLine(sv.Name, fmt.Sprintf("attempt %d of %d %s, retrying in %v", attempt, attempts, status, delay))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *Runner) runBuildAttempt(ctx context.Context, build *Build, sv *ProcessType, fn, inputsDigest string, attempt int) string {
+	r.setServiceState(normalizeByEnvVarRules(sv.Name), BuildRunning)
+	r.publish(EventBuild, sv.Name, BuildRunning)
+	step := r.addStep(build, sv.Name, attempt)
+	stepCtx := ctx
+	if sv.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeoutCause(ctx, sv.Timeout, errStepTimedOut)
+		defer cancel()
+	}
+	var buf bytes.Buffer
+	err := r.startProcess(stepCtx, sv, -1, -1, fn, &buf)
+	status := BuildDone
+	switch {
+	case err != nil && errors.Is(context.Cause(stepCtx), errStepTimedOut):
+		status = BuildTimedOut
+		r.logLine(sv.Name, fmt.Sprintf("timed out after %v", sv.Timeout))
+		fmt.Fprintf(&buf, "timed out after %v\n", sv.Timeout)
+	case err != nil:
+		status = BuildErrored
+	}
+	if err != nil {
+		r.setServiceState("ERROR_"+normalizeByEnvVarRules(sv.Name), buf.String())
+		r.setDiagnostics(sv, buf.String())
+	} else {
+		r.deleteServiceState("ERROR_" + normalizeByEnvVarRules(sv.Name))
+		r.setDiagnostics(sv, "")
+		if r.buildCache != nil {
+			r.buildCache.store(sv.Name, r.buildStepKey(sv, inputsDigest))
+		}
+	}
+	r.finishStep(step, status, err, buf.String())
+	r.setServiceState(normalizeByEnvVarRules(sv.Name), status)
+	r.publish(EventBuild, sv.Name, status)
+	return status
+}
+
+// validateBuildOrder ensures that build steps only run after other build steps
+// and that they do not depend on each other in a cycle.
+func (r *Runner) validateBuildOrder() error {
+	after := make(map[string][]string)
+	for _, sv := range r.Processes {
+		if strings.HasPrefix(sv.Name, "build") {
+			after[sv.Name] = sv.After
+		}
+	}
+	for name, deps := range after {
+		for _, dep := range deps {
+			if _, ok := after[dep]; !ok {
+				return fmt.Errorf("%w: %s runs after unknown build step %q", ErrInvalidBuildOrder, name, dep)
+			}
+		}
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("%w: cycle %s", ErrInvalidBuildOrder, strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dep := range after[name] {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	for _, sv := range r.Processes {
+		if _, ok := after[sv.Name]; ok {
+			if err := visit(sv.Name, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) skipBuildStep(build *Build, sv *ProcessType) {
+	step := r.addStep(build, sv.Name, 0)
+	r.finishStep(step, BuildSkipped, nil, "")
+	r.setServiceState(normalizeByEnvVarRules(sv.Name), BuildSkipped)
+	r.publish(EventBuild, sv.Name, BuildSkipped)
+}
+
+func (r *Runner) runPermanent(changedFileName string) (*oversight.Tree, []*ProcessType) {
+	var started []*ProcessType
 	tree := oversight.New(
 		oversight.WithRestartStrategy(oversight.OneForAll()),
 		oversight.NeverHalt())
 	for j, sv := range r.Processes {
-		if strings.HasPrefix(sv.Name, "build") {
+		if !r.isService(sv) {
 			continue
 		}
 		maxProc := r.Formation[sv.Name]
@@ -290,11 +703,18 @@ func (r *Runner) runPermanent(changedFileName string) *oversight.Tree {
 			if sv.Restart == Loop || sv.Restart == Temporary || sv.Restart == OnFailure {
 				continue
 			}
+			if len(sv.Consumes) > 0 {
+				portCount++
+				continue
+			}
+			if i == 0 {
+				started = append(started, sv)
+			}
 			_ = tree.Add(oversight.ChildProcessSpecification{
 				Name:    sv.Name,
 				Restart: oversight.Permanent(),
 				Start: func(ctx context.Context) error {
-					ok := r.startProcess(ctx, sv, i, pc, changedFileName, io.Discard)
+					ok := r.runInstance(ctx, sv, i, pc, changedFileName, io.Discard)
 					if !ok && sv.Restart == OnFailure {
 						return errors.New("restarting on failure")
 					}
@@ -304,7 +724,7 @@ func (r *Runner) runPermanent(changedFileName string) *oversight.Tree {
 			portCount++
 		}
 	}
-	return tree
+	return tree, started
 }
 
 func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
@@ -312,7 +732,7 @@ func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
 		oversight.WithRestartStrategy(oversight.OneForAll()),
 		oversight.NeverHalt())
 	for j, sv := range r.Processes {
-		if strings.HasPrefix(sv.Name, "build") {
+		if !r.isService(sv) {
 			continue
 		}
 		maxProc := r.Formation[sv.Name]
@@ -324,7 +744,7 @@ func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
 					Name:    sv.Name,
 					Restart: oversight.Permanent(),
 					Start: func(ctx context.Context) error {
-						r.startProcess(ctx, sv, i, pc, changedFileName, io.Discard)
+						r.runInstance(ctx, sv, i, pc, changedFileName, io.Discard)
 						return nil
 					},
 				})
@@ -334,7 +754,7 @@ func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
 					Name:    sv.Name,
 					Restart: oversight.Temporary(),
 					Start: func(ctx context.Context) error {
-						r.startProcess(ctx, sv, i, pc, changedFileName, io.Discard)
+						r.runInstance(ctx, sv, i, pc, changedFileName, io.Discard)
 						return nil
 					},
 				})
@@ -344,7 +764,7 @@ func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
 					Name:    sv.Name,
 					Restart: oversight.Transient(),
 					Start: func(ctx context.Context) error {
-						r.startProcess(ctx, sv, i, pc, changedFileName, io.Discard)
+						r.runInstance(ctx, sv, i, pc, changedFileName, io.Discard)
 						return nil
 					},
 				})
@@ -355,6 +775,97 @@ func (r *Runner) runEphemeral(ctx context.Context, changedFileName string) {
 	_ = tree.Start(ctx)
 }
 
+// runConsumers starts the process types that declared which build outputs
+// they consume. Unlike the ones started by runPermanent, they outlive builds
+// and are only restarted by restartConsumers.
+func (r *Runner) runConsumers(ctx context.Context, changedFileName string) {
+	tree := oversight.New(
+		oversight.WithRestartStrategy(oversight.OneForOne()),
+		oversight.NeverHalt())
+	for j, sv := range r.Processes {
+		if !r.isService(sv) {
+			continue
+		}
+		maxProc := r.Formation[sv.Name]
+		portCount := j * 100
+		for i := 0; i < maxProc; i++ {
+			sv, i, pc := sv, i, portCount
+			if sv.Restart == Loop || sv.Restart == Temporary || sv.Restart == OnFailure {
+				continue
+			}
+			portCount++
+			if len(sv.Consumes) == 0 {
+				continue
+			}
+			_ = tree.Add(oversight.ChildProcessSpecification{
+				Name:    instanceName(sv.Name, i),
+				Restart: oversight.Permanent(),
+				Start: func(ctx context.Context) error {
+					r.runInstance(ctx, sv, i, pc, changedFileName, io.Discard)
+					return nil
+				},
+			})
+		}
+	}
+	if len(tree.Children()) == 0 {
+		return
+	}
+	_ = tree.Start(ctx)
+}
+
+// restartConsumers restarts the instances of the process types that consume
+// any of the changed build outputs, and reports which process types were
+// restarted.
+func (r *Runner) restartConsumers(changedOutputs map[string]bool, changedFileName string) []*ProcessType {
+	var restarted []*ProcessType
+	for _, sv := range r.Processes {
+		if len(sv.Consumes) == 0 || strings.HasPrefix(sv.Name, "build") || r.Formation[sv.Name] == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(sv.Consumes, func(output string) bool {
+			return changedOutputs[filepath.Clean(output)]
+		}) {
+			log.Println("build outputs unchanged, not restarting:", sv.Name)
+			continue
+		}
+		restarted = append(restarted, sv)
+		for i := 0; i < r.Formation[sv.Name]; i++ {
+			r.instance(instanceName(sv.Name, i)).restart(changedFileName)
+		}
+	}
+	return restarted
+}
+
+// hashOutputs hashes the declared outputs of all build steps and reports
+// which of them changed since the last time they were hashed.
+func (r *Runner) hashOutputs() map[string]bool {
+	changed := make(map[string]bool)
+	for _, sv := range r.Processes {
+		if !strings.HasPrefix(sv.Name, "build") {
+			continue
+		}
+		for _, output := range sv.Outputs {
+			output = filepath.Clean(output)
+			h, err := hashFile(filepath.Join(r.WorkDir, output))
+			if err != nil {
+				log.Println("cannot hash build output:", err)
+			}
+			if prev, ok := r.outputHashes[output]; !ok || prev != h {
+				changed[output] = true
+			}
+			r.outputHashes[output] = h
+		}
+	}
+	return changed
+}
+
+func instanceName(procType string, procCount int) string {
+	if procCount > -1 {
+		return fmt.Sprintf("%v.%v", procType, procCount)
+	}
+	return procType
+}
+
 // normalizeByEnvVarRules takes any name and rewrites it to be compliant with
 // the POSIX standards on shells section of IEEE Std 1003.1-2008 / IEEE POSIX
 // P1003.2/ISO 9945.2 Shell and Tools standard.
@@ -373,49 +884,84 @@ func normalizeByEnvVarRules(name string) string {
 	return strings.ToUpper(buf.String())
 }
 
-func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string, buf io.Writer) bool {
+func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string, buf io.Writer) error {
 	pr, pw := io.Pipe()
-	procName := sv.Name
-	if procCount > -1 {
-		procName = fmt.Sprintf("%v.%v", procName, procCount)
-	}
+	procName := instanceName(sv.Name, procCount)
 	r.prefixedPrinter(ctx, pr, procName)
 	defer pw.Close()
 	defer pr.Close()
 	fmt.Fprintln(pw, "running", `"`+sv.Cmd+`"`)
 	defer fmt.Fprintln(pw, "finished", `"`+sv.Cmd+`"`)
 	fmt.Fprintln(pw)
-	c := command(ctx, sv.Cmd)
-	c.Dir = r.WorkDir
-	c.Env = os.Environ()
-	if len(r.BaseEnvironment) > 0 {
-		c.Env = append(c.Env, r.BaseEnvironment...)
-	}
-	c.Env = append(c.Env, fmt.Sprintf("PS=%v", procName))
-	if r.ServiceDiscoveryAddr != "" {
-		c.Env = append(c.Env, fmt.Sprintf("DISCOVERY=%v", r.ServiceDiscoveryAddr))
-	}
-	c.Env = append(c.Env, fmt.Sprintf("CHANGED_FILENAME=%v", changedFileName))
-	stderrPipe, err := c.StderrPipe()
-	if err != nil {
-		fmt.Fprintln(pw, "cannot open stderr pipe", procName, sv.Cmd, err)
-		return false
+	var sock *socket
+	cmd := sv.Cmd
+	if sv.Socket != "" && procCount > -1 {
+		var err error
+		sock, err = r.socket(sv, procCount)
+		if err != nil {
+			fmt.Fprintln(pw, "cannot open socket", procName, err)
+			return err
+		}
+		// LISTEN_PID must match the process that consumes the socket,
+		// which is only known after the shell starts. Simple commands
+		// are exec'd in place by the shell so the PID matches.
+		if shell.Simple(cmd) {
+			cmd = execInPlace(cmd)
+		}
+		cmd = "LISTEN_PID=$$; export LISTEN_PID; " + cmd
 	}
-	stdoutPipe, err := c.StdoutPipe()
+	vars, err := r.processVars(sv)
 	if err != nil {
-		fmt.Fprintln(pw, "cannot open stdout pipe", procName, sv.Cmd, err)
-		return false
+		fmt.Fprintln(pw, "cannot load environment", procName, err)
+		return err
+	}
+	var stopTimeout time.Duration
+	if !strings.HasPrefix(sv.Name, "build") {
+		stopTimeout = sv.Timeout
+	}
+	c := command(ctx, cmd, sv.Signal, stopTimeout)
+	c.Dir = r.processDir(sv)
+	c.Env = slices.Concat(os.Environ(), vars)
+	var (
+		port int
+		addr string
+	)
+	if sock != nil {
+		c.ExtraFiles = []*os.File{sock.file}
+		port = sock.port
+		addr = fmt.Sprintf("localhost:%v", sock.port)
+	} else {
+		port, addr = r.instancePort(vars, portCount)
 	}
-	r.prefixedPrinter(ctx, io.TeeReader(stderrPipe, buf), procName)
-	r.prefixedPrinter(ctx, io.TeeReader(stdoutPipe, buf), procName)
+	c.Env = append(c.Env, r.runnerEnv(procName, port, sock != nil, changedFileName)...)
+	// Sharing the same writer for stdout and stderr makes exec.Cmd
+	// serialize their writes, and c.Wait only returns after all the output
+	// has been copied into buf.
+	outR, outW := io.Pipe()
+	defer outW.Close()
+	r.prefixedPrinter(ctx, outR, procName)
+	output := io.MultiWriter(buf, outW)
+	c.Stdout, c.Stderr = output, output
+	c.WaitDelay = time.Second + stopTimeout
+	inst := r.instance(procName)
 	if sv.WaitFor != "" {
+		inst.setState(StateWaiting)
 		r.waitFor(ctx, pw, sv.WaitFor)
 	}
-	if err := c.Run(); err != nil {
+	if err := c.Start(); err != nil {
 		fmt.Fprintf(pw, "exec error %s: (%s) %v\n", procName, sv.Cmd, err)
-		return false
+		inst.exited(false)
+		return err
 	}
-	return true
+	inst.running(c.Process, addr)
+	if err := c.Wait(); err != nil {
+		fmt.Fprintf(pw, "exec error %s: (%s) %v\n", procName, sv.Cmd, err)
+		// processes interrupted by the runner are not failures.
+		inst.exited(ctx.Err() != nil)
+		return err
+	}
+	inst.exited(true)
+	return nil
 }
 
 func (r *Runner) waitFor(ctx context.Context, w io.Writer, target string) {
@@ -441,13 +987,7 @@ func (r *Runner) prefixedPrinter(ctx context.Context, rdr io.Reader, name string
 	scanner.Buffer(make([]byte, 65536), 2*1048576)
 	go func() {
 		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Println(paddedName+":", line)
-			r.logs <- LogMessage{
-				PaddedName: paddedName,
-				Name:       name,
-				Line:       line,
-			}
+			r.logLine(name, scanner.Text())
 		}
 		if ctx.Err() != nil {
 			return
@@ -459,6 +999,18 @@ func (r *Runner) prefixedPrinter(ctx context.Context, rdr io.Reader, name string
 	return scanner
 }
 
+// logLine prints a line on behalf of the named process and forwards it to
+// the log subscribers.
+func (r *Runner) logLine(name, line string) {
+	paddedName := (name + strings.Repeat(" ", r.longestProcessTypeName))[:r.longestProcessTypeName]
+	fmt.Println(paddedName+":", line)
+	r.logs <- LogMessage{
+		PaddedName: paddedName,
+		Name:       name,
+		Line:       line,
+	}
+}
+
 func (r *Runner) setServiceState(svc, state string) {
 	r.servicesMu.Lock()
 	r.serviceStates[svc] = state
@@ -487,6 +1039,7 @@ func isValidGitDir(dir string) bool {
 func (s *Runner) monitorGitDir(ctx context.Context, dir string) <-chan string {
 	triggereds := make(chan string, 1)
 	triggereds <- ""
+	patterns := s.watchPatterns()
 	go func() {
 		defer close(triggereds)
 		t := time.NewTicker(50 * time.Millisecond)
@@ -526,6 +1079,9 @@ func (s *Runner) monitorGitDir(ctx context.Context, dir string) <-chan string {
 			files = slices.Compact(files)
 		filesLoop:
 			for _, path := range files {
+				if strings.HasPrefix(path, stateDirName+"/") {
+					continue
+				}
 				for _, skipDir := range s.SkipDirs {
 					if skipDir == "" {
 						continue
@@ -534,7 +1090,7 @@ func (s *Runner) monitorGitDir(ctx context.Context, dir string) <-chan string {
 						continue filesLoop
 					}
 				}
-				for _, p := range s.Observables {
+				for _, p := range patterns {
 					if !match(p, path) {
 						continue
 					}
@@ -564,6 +1120,7 @@ func (s *Runner) monitorGitDir(ctx context.Context, dir string) <-chan string {
 func (s *Runner) monitorWorkDirScanner(ctx context.Context) <-chan string {
 	triggereds := make(chan string, 1)
 	triggereds <- ""
+	patterns := s.watchPatterns()
 	go func() {
 		defer close(triggereds)
 		t := time.NewTicker(50 * time.Millisecond)
@@ -580,6 +1137,9 @@ func (s *Runner) monitorWorkDirScanner(ctx context.Context) <-chan string {
 					return err
 				}
 				if info.IsDir() {
+					if path == filepath.Join(s.WorkDir, stateDirName) {
+						return filepath.SkipDir
+					}
 					for _, skipDir := range s.SkipDirs {
 						if skipDir == "" {
 							continue
@@ -590,7 +1150,7 @@ func (s *Runner) monitorWorkDirScanner(ctx context.Context) <-chan string {
 					}
 					return nil
 				}
-				for _, p := range s.Observables {
+				for _, p := range patterns {
 					if !match(p, path) {
 						continue
 					}
@@ -638,12 +1198,25 @@ func match(p, path string) bool {
 	return true
 }
 
-func command(ctx context.Context, cmd string) *exec.Cmd {
+// command prepares cmd to run in its own process group, which receives the
+// stop signal, SIGTERM if zero, when ctx is done. When stopTimeout is set, the
+// process group is killed if it is still running that long after the stop
+// signal.
+func command(ctx context.Context, cmd string, stop syscall.Signal, stopTimeout time.Duration) *exec.Cmd {
 	c := exec.CommandContext(ctx, "sh", "-c", cmd)
 	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	c.Cancel = func() error {
 		pgid := -c.Process.Pid
-		osSignal := syscall.SIGTERM
+		osSignal := cmp.Or(stop, syscall.SIGTERM)
+		if errors.Is(context.Cause(ctx), errStepTimedOut) {
+			osSignal = syscall.SIGKILL
+		}
+		if stopTimeout > 0 {
+			kill := time.AfterFunc(stopTimeout, func() {
+				_ = syscall.Kill(pgid, syscall.SIGKILL)
+			})
+			defer kill.Stop()
+		}
 		if err := c.Process.Signal(osSignal); err != nil {
 			return fmt.Errorf("cannot signal process: %w", err)
 		}
@@ -15,7 +15,22 @@
 package runner
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestMatch(t *testing.T) {
@@ -47,3 +62,946 @@ func TestMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want syscall.Signal
+	}{
+		{"SIGHUP", syscall.SIGHUP},
+		{"hup", syscall.SIGHUP},
+		{"Usr1", syscall.SIGUSR1},
+		{"15", syscall.SIGTERM},
+		{"SIGBOGUS", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := ParseSignal(tt.in); got != tt.want {
+				t.Errorf("ParseSignal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketAddress(t *testing.T) {
+	tests := []struct {
+		spec      string
+		procCount int
+		want      string
+		wantErr   bool
+	}{
+		{"tcp", 0, "localhost:0", false},
+		{"tcp", 3, "localhost:0", false},
+		{"tcp:8080", 0, "localhost:8080", false},
+		{"tcp:8080", 2, "localhost:8082", false},
+		{"tcp:0.0.0.0:9000", 1, "0.0.0.0:9001", false},
+		{"tcp:localhost:http", 0, "", true},
+		{"udp:8080", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := socketAddress(tt.spec, tt.procCount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("socketAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("socketAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecInPlace(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"./server", "exec ./server"},
+		{"FOO=bar ./server --flag", "FOO=bar exec ./server --flag"},
+		{`A="x y" B='z' ./server`, `A="x y" B='z' exec ./server`},
+		{"cd dir", "cd dir"},
+		{". ./env", ". ./env"},
+		{"FOO=bar cd dir", "FOO=bar cd dir"},
+		{"exec ./server", "exec ./server"},
+		{"(./server)", "(./server)"},
+		{"FOO=bar", "FOO=bar"},
+	}
+	for _, tt := range tests {
+		if got := execInPlace(tt.cmd); got != tt.want {
+			t.Errorf("execInPlace(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestSocketActivationWithAssignments(t *testing.T) {
+	dir := t.TempDir()
+	r := New()
+	r.WorkDir = dir
+	defer r.closeSockets()
+	sv := &ProcessType{
+		Name:   "web",
+		Socket: "tcp",
+		Cmd:    `VAR=x sh -c 'echo "$VAR $LISTEN_PID $$ $LISTEN_FDS" > out'`,
+	}
+	if err := r.startProcess(context.Background(), sv, 0, 0, "", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 4 || fields[0] != "x" || fields[1] != fields[2] || fields[3] != "1" {
+		t.Errorf("the command must run in place of the shell with its assignments, got: %q", b)
+	}
+}
+
+func TestSocketActivationWithQuotedOperators(t *testing.T) {
+	dir := t.TempDir()
+	r := New()
+	r.WorkDir = dir
+	defer r.closeSockets()
+	sv := &ProcessType{
+		Name:   "web",
+		Socket: "tcp",
+		Cmd:    `sh -c 'echo "$LISTEN_PID $$ a|b;c&d" > out'`,
+	}
+	if err := r.startProcess(context.Background(), sv, 0, 0, "", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 3 || fields[0] != fields[1] || fields[2] != "a|b;c&d" {
+		t.Errorf("commands with quoted operators must run in place of the shell, got: %q", b)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	dir := t.TempDir()
+	r := New()
+	r.WorkDir = dir
+	sv := &ProcessType{
+		Name:    "web",
+		Cmd:     `trap "" TERM; sleep 30 & echo $! > pid; wait`,
+		Timeout: 200 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if _, err := os.Stat(filepath.Join(dir, "pid")); err == nil {
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	_ = r.startProcess(ctx, sv, 0, -1, "", io.Discard)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("stopping took too long: %v", elapsed)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := "/proc/" + strings.TrimSpace(string(b)) + "/stat"
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, err := os.ReadFile(stat)
+		if err != nil || strings.Contains(string(b), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("processes ignoring the stop signal must be killed after the timeout")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestProxyRoute(t *testing.T) {
+	p := &Proxy{
+		Routes: []ProxyRoute{
+			{PathPrefix: "/", ProcessType: "web"},
+			{PathPrefix: "/api", ProcessType: "api"},
+			{Host: "admin.localhost", ProcessType: "admin"},
+			{Host: "admin.localhost", PathPrefix: "/api", ProcessType: "admin-api"},
+		},
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://localhost:8080/", "web"},
+		{"http://localhost:8080/index.html", "web"},
+		{"http://localhost:8080/api/v1", "api"},
+		{"http://localhost:8080/api", "api"},
+		{"http://localhost:8080/api/", "api"},
+		{"http://localhost:8080/apix", "web"},
+		{"http://localhost:8080/api-docs", "web"},
+		{"http://admin.localhost:8080/", "admin"},
+		{"http://admin.localhost:8080/api-docs", "admin"},
+		{"http://admin.localhost:8080/api/v1", "admin-api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			got, ok := p.route(req)
+			if !ok {
+				t.Fatal("expected route not found")
+			}
+			if got.ProcessType != tt.want {
+				t.Errorf("route() = %v, want %v", got.ProcessType, tt.want)
+			}
+		})
+	}
+	empty := &Proxy{Routes: []ProxyRoute{{PathPrefix: "/api", ProcessType: "api"}}}
+	if _, ok := empty.route(httptest.NewRequest("GET", "http://localhost/", nil)); ok {
+		t.Error("unexpected route found")
+	}
+	if _, ok := empty.route(httptest.NewRequest("GET", "http://localhost/apix", nil)); ok {
+		t.Error("routes must match whole path segments")
+	}
+}
+
+func TestReload(t *testing.T) {
+	r := New()
+	r.Observables = []string{"*.go", "!*_test.go"}
+	r.Processes = []*ProcessType{
+		{Name: "web", Reload: syscall.SIGHUP, ReloadOn: []string{"*.tmpl", "templates.go", "*_test.go"}},
+		{Name: "worker", ReloadOn: []string{"*.yaml"}},
+	}
+	tests := []struct {
+		changedFileName string
+		want            bool
+	}{
+		{"views/index.tmpl", true},
+		{"templates.go", false},
+		{"templates_test.go", true},
+		{"main.go", false},
+		{"config.yaml", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.changedFileName, func(t *testing.T) {
+			if got := r.reload(tt.changedFileName); got != tt.want {
+				t.Errorf("reload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFireLiveReload(t *testing.T) {
+	r := New()
+	web := &ProcessType{Name: "web"}
+	r.Processes = []*ProcessType{web}
+	r.Formation["web"] = 1
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r.instance("web.0").running(nil, l.Addr().String())
+	runs := r.instanceRuns()
+	events := r.subscribeEvents()
+	defer r.unsubscribeEvents(events)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.fireLiveReload(ctx, "style.css", []*ProcessType{web}, runs)
+	select {
+	case ev := <-events:
+		t.Fatalf("live reload must wait for the restarted instances to start again: %+v", ev)
+	case <-time.After(300 * time.Millisecond):
+	}
+	r.instance("web.0").running(nil, l.Addr().String())
+	select {
+	case ev := <-events:
+		if ev.Type != EventLiveReload || ev.Detail != liveReloadCSS {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("live reload must fire once the restarted instances are reachable")
+	}
+}
+
+func TestInjectLiveReload(t *testing.T) {
+	r := New()
+	r.LiveReload = true
+	r.ServiceDiscoveryAddr = ":64000"
+	tests := []struct {
+		name        string
+		host        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"body", "localhost:8080", "text/html; charset=utf-8", "<html><body>hi</body></html>", `<html><body>hi<script src="//localhost:64000/livereload.js"></script></body></html>`},
+		{"uppercase", "localhost:8080", "text/html", "<HTML><BODY>hi</BODY></HTML>", `<HTML><BODY>hi<script src="//localhost:64000/livereload.js"></script></BODY></HTML>`},
+		{"non-ascii", "localhost:8080", "text/html", "<body>İİİ</body>", `<body>İİİ<script src="//localhost:64000/livereload.js"></script></body>`},
+		{"request host", "192.168.0.10:8080", "text/html", "<body>hi</body>", `<body>hi<script src="//192.168.0.10:64000/livereload.js"></script></body>`},
+		{"ipv6 host", "[::1]:8080", "text/html", "<body>hi</body>", `<body>hi<script src="//[::1]:64000/livereload.js"></script></body>`},
+		{"no request host", "", "text/html", "<body>hi</body>", `<body>hi<script src="//localhost:64000/livereload.js"></script></body>`},
+		{"fragment", "localhost:8080", "text/html", "hi", `hi<script src="//localhost:64000/livereload.js"></script>`},
+		{"json", "localhost:8080", "application/json", "{}", "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:  http.Header{"Content-Type": []string{tt.contentType}},
+				Body:    io.NopCloser(strings.NewReader(tt.body)),
+				Request: &http.Request{Host: tt.host},
+			}
+			if err := r.injectLiveReload(resp); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("injectLiveReload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceControls(t *testing.T) {
+	inst := &instance{name: "web.0", state: StatePending}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if !inst.waitStart(ctx) {
+		t.Fatal("instances must start unless stopped")
+	}
+	inst.stop()
+	if inst.waitStart(ctx) {
+		t.Fatal("stopped instances must wait to be started")
+	}
+	if got := inst.snapshot("web").State; got != StateStopped {
+		t.Errorf("unexpected state: %v", got)
+	}
+	inst.restart("")
+	if !inst.waitStart(context.Background()) {
+		t.Fatal("restarting stopped instances must start them")
+	}
+	if _, restart := inst.takeRestart(); restart {
+		t.Error("restarting stopped instances must only start them")
+	}
+	inst.stop()
+	go inst.start()
+	if !inst.waitStart(context.Background()) {
+		t.Fatal("started instances must resume")
+	}
+}
+
+func TestRestartConsumers(t *testing.T) {
+	processes := []*ProcessType{
+		{Name: "build", Outputs: []string{"bin/api", "bin/worker"}},
+		{Name: "api", Consumes: []string{"bin/api"}},
+		{Name: "worker", Consumes: []string{"./bin/worker"}},
+		{Name: "both", Consumes: []string{"bin/api", "bin/worker"}},
+		{Name: "idle", Consumes: []string{"bin/api"}},
+		{Name: "web"},
+	}
+	formation := map[string]int{"build": 1, "api": 2, "worker": 1, "both": 1, "web": 1}
+	instances := []string{"api.0", "api.1", "worker.0", "both.0", "idle.0", "web.0"}
+	tests := []struct {
+		name           string
+		changedOutputs map[string]bool
+		wantRestarted  []string
+		wantInstances  []string
+	}{
+		{"none", map[string]bool{}, nil, nil},
+		{"api", map[string]bool{"bin/api": true}, []string{"api", "both"}, []string{"api.0", "api.1", "both.0"}},
+		{"worker", map[string]bool{"bin/worker": true}, []string{"worker", "both"}, []string{"worker.0", "both.0"}},
+		{"all", map[string]bool{"bin/api": true, "bin/worker": true}, []string{"api", "worker", "both"}, []string{"api.0", "api.1", "worker.0", "both.0"}},
+		{"unrelated", map[string]bool{"bin/other": true}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.Processes = processes
+			r.Formation = formation
+			for _, name := range instances {
+				r.instance(name).activate()
+			}
+			var restarted []string
+			for _, sv := range r.restartConsumers(tt.changedOutputs, "main.go") {
+				restarted = append(restarted, sv.Name)
+			}
+			if diff := cmp.Diff(tt.wantRestarted, restarted); diff != "" {
+				t.Errorf("restartConsumers() mismatch (-want +got):\n%s", diff)
+			}
+			var restartedInstances []string
+			for _, name := range instances {
+				if fn, restart := r.instance(name).takeRestart(); restart {
+					if fn != "main.go" {
+						t.Errorf("%s restarted with unexpected changed file %q", name, fn)
+					}
+					restartedInstances = append(restartedInstances, name)
+				}
+			}
+			if diff := cmp.Diff(tt.wantInstances, restartedInstances); diff != "" {
+				t.Errorf("restarted instances mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHashOutputs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) func() {
+		return func() {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r := New()
+	r.WorkDir = dir
+	r.Processes = []*ProcessType{
+		{Name: "build-api", Outputs: []string{"./bin/api"}},
+		{Name: "build-worker", Outputs: []string{"bin/worker"}},
+		{Name: "api", Outputs: []string{"bin/ignored"}, Consumes: []string{"bin/api"}},
+		{Name: "worker", Consumes: []string{"bin/worker"}},
+	}
+	r.Formation = map[string]int{"build-api": 1, "build-worker": 1, "api": 1, "worker": 1}
+	// the builds run in order, and each one sees the hashes of the
+	// previous ones.
+	tests := []struct {
+		name          string
+		build         func()
+		wantChanged   map[string]bool
+		wantRestarted []string
+	}{
+		{"first build", func() { write("bin/api", "api v1")(); write("bin/worker", "worker v1")() }, map[string]bool{"bin/api": true, "bin/worker": true}, []string{"api", "worker"}},
+		{"nothing rebuilt", func() {}, map[string]bool{}, nil},
+		{"same content", func() { write("bin/api", "api v1")(); write("bin/worker", "worker v1")() }, map[string]bool{}, nil},
+		{"api changed", write("bin/api", "api v2"), map[string]bool{"bin/api": true}, []string{"api"}},
+		{"worker changed", write("bin/worker", "worker v2"), map[string]bool{"bin/worker": true}, []string{"worker"}},
+		{"ignored output", write("bin/ignored", "ignored"), map[string]bool{}, nil},
+		{"worker removed", func() { os.Remove(filepath.Join(dir, "bin/worker")) }, map[string]bool{"bin/worker": true}, []string{"worker"}},
+	}
+	for _, tt := range tests {
+		tt.build()
+		changed := r.hashOutputs()
+		if diff := cmp.Diff(tt.wantChanged, changed); diff != "" {
+			t.Errorf("%s: hashOutputs() mismatch (-want +got):\n%s", tt.name, diff)
+		}
+		var restarted []string
+		for _, sv := range r.restartConsumers(changed, "") {
+			restarted = append(restarted, sv.Name)
+		}
+		if diff := cmp.Diff(tt.wantRestarted, restarted); diff != "" {
+			t.Errorf("%s: restartConsumers() mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	r := New()
+	r.WorkDir = "/src"
+	r.BuildHistorySize = 2
+	for i := 0; i < 3; i++ {
+		b := r.newBuild("/src/main.go")
+		step := r.addStep(b, "build", 1)
+		r.finishStep(step, BuildErrored, errors.New("cannot start"), "output")
+		r.finishBuild(b, false)
+	}
+	builds := r.Builds()
+	if len(builds) != 2 {
+		t.Fatalf("history must be bounded, got %d builds", len(builds))
+	}
+	if builds[0].ID != 2 || builds[1].ID != 3 {
+		t.Errorf("history must keep the newest builds in order, got %d and %d", builds[0].ID, builds[1].ID)
+	}
+	if builds[1].Trigger != "main.go" {
+		t.Errorf("trigger must be relative to workdir, got %q", builds[1].Trigger)
+	}
+	if builds[1].Steps[0].Output != "" {
+		t.Error("build listings must not carry step outputs")
+	}
+	if builds[1].Steps[0].ExitCode != -1 || builds[1].Status != BuildErrored {
+		t.Errorf("unexpected step record: %+v", builds[1].Steps[0])
+	}
+	b, ok := r.BuildByID(3)
+	if !ok || b.Steps[0].Output != "output" {
+		t.Error("build details must carry step outputs")
+	}
+	if _, ok := r.BuildByID(1); ok {
+		t.Error("evicted builds must not be found")
+	}
+}
+
+func TestValidateBuildOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		procs   []*ProcessType
+		wantErr bool
+	}{
+		{"no edges", []*ProcessType{{Name: "build-a"}, {Name: "build-b"}}, false},
+		{"edge", []*ProcessType{{Name: "build-a", After: []string{"build-b"}}, {Name: "build-b"}}, false},
+		{"unknown", []*ProcessType{{Name: "build-a", After: []string{"build-c"}}}, true},
+		{"not a build", []*ProcessType{{Name: "build-a", After: []string{"web"}}, {Name: "web"}}, true},
+		{"cycle", []*ProcessType{
+			{Name: "build-a", After: []string{"build-c"}},
+			{Name: "build-b", After: []string{"build-a"}},
+			{Name: "build-c", After: []string{"build-b"}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.Processes = tt.procs
+			err := r.validateBuildOrder()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBuildOrder) {
+				t.Errorf("unexpected error type: %v", err)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	newRunner := func() *Runner {
+		r := New()
+		r.Processes = []*ProcessType{
+			{Name: "web", Groups: []string{"frontend"}},
+			{Name: "api", Groups: []string{"backend", "frontend"}},
+			{Name: "worker", Groups: []string{"backend"}},
+			{Name: "db", Groups: []string{"backend"}},
+		}
+		r.Formation = map[string]int{"web": 1, "api": 2, "worker": 1}
+		r.Profiles = map[string]map[string]int{
+			"frontend-only": {"@frontend": 1},
+		}
+		return r
+	}
+	tests := []struct {
+		name    string
+		sel     Selection
+		want    map[string]int
+		reasons []string
+		wantErr error
+	}{
+		{
+			name:    "formation",
+			want:    map[string]int{"web": 1, "api": 2, "worker": 1, "db": 0},
+			reasons: []string{"formation", "formation", "formation", "not in formation"},
+		},
+		{
+			name:    "profile",
+			sel:     Selection{Profile: "frontend-only", Skip: []string{"web"}},
+			want:    map[string]int{"web": 0, "api": 1, "worker": 0, "db": 0},
+			reasons: []string{"skip", "profile frontend-only", "not in profile frontend-only", "not in profile frontend-only"},
+		},
+		{
+			name:    "only group",
+			sel:     Selection{Only: []string{"@backend"}},
+			want:    map[string]int{"web": 0, "api": 1, "worker": 1, "db": 1},
+			reasons: []string{"not listed in only", "only @backend", "only @backend", "only @backend"},
+		},
+		{
+			name:    "skip and optional",
+			sel:     Selection{Skip: []string{"@frontend"}, Optional: []string{"db"}},
+			want:    map[string]int{"web": 0, "api": 0, "worker": 1, "db": 1},
+			reasons: []string{"skip @frontend", "skip @frontend", "formation", "optional"},
+		},
+		{name: "unknown profile", sel: Selection{Profile: "ghost"}, wantErr: ErrUnknownProfile},
+		{name: "unknown group", sel: Selection{Only: []string{"@ghost"}}, wantErr: ErrUnknownGroup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRunner()
+			err := r.Select(tt.sel)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			sel, ok := r.Selection()
+			if !ok {
+				t.Fatal("missing selection")
+			}
+			got := make(map[string]int)
+			var reasons []string
+			for _, sv := range sel.ProcessTypes {
+				got[sv.Name] = sv.Instances
+				reasons = append(reasons, sv.Reason)
+				if r.Formation[sv.Name] != sv.Instances {
+					t.Errorf("formation of %s: %d, selection: %d", sv.Name, r.Formation[sv.Name], sv.Instances)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected instances: %s", diff)
+			}
+			if diff := cmp.Diff(tt.reasons, reasons); diff != "" {
+				t.Errorf("unexpected reasons: %s", diff)
+			}
+		})
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "web.env"), []byte("A=envfile\nB=envfile\nC=envfile\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RUNNER_TEST_A", "os")
+	t.Setenv("PORT", "")
+	os.Unsetenv("PORT")
+	r := New()
+	r.WorkDir = dir
+	r.BasePort = 5000
+	r.ServiceDiscoveryAddr = "localhost:64000"
+	r.BaseEnvironment = []string{"RUNNER_TEST_A=base", "A=base"}
+	r.Processes = []*ProcessType{
+		{Name: "build", Env: []string{"B=env"}},
+		{Name: "web", EnvFiles: []string{"web.env"}, Env: []string{"B=env", "C=env", "C=last"}, Dir: "frontend"},
+		{Name: "missing", EnvFiles: []string{"missing.env"}},
+		{Name: "api", Env: []string{"PORT=8080"}},
+	}
+	got, err := r.Environment("web.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := make(map[string]string)
+	for _, kv := range got {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	expected := map[string]string{
+		"RUNNER_TEST_A":    "base",
+		"A":                "envfile",
+		"B":                "env",
+		"C":                "last",
+		"PORT":             "5102",
+		"PS":               "web.2",
+		"DISCOVERY":        "localhost:64000",
+		"CHANGED_FILENAME": "",
+	}
+	for k, v := range expected {
+		if vars[k] != v {
+			t.Errorf("%s: got %q, expected %q", k, vars[k], v)
+		}
+	}
+	if !slices.IsSortedFunc(got, func(a, b string) int {
+		ka, _, _ := strings.Cut(a, "=")
+		kb, _, _ := strings.Cut(b, "=")
+		return strings.Compare(ka, kb)
+	}) {
+		t.Error("environment must be sorted by name")
+	}
+	if got := r.processDir(r.Processes[1]); got != filepath.Join(dir, "frontend") {
+		t.Errorf("unexpected dir: %s", got)
+	}
+
+	build, err := r.Environment("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(build, "PS=build") || slices.ContainsFunc(build, func(kv string) bool {
+		return strings.HasPrefix(kv, "PORT=")
+	}) {
+		t.Errorf("build steps must not get a port: %v", build)
+	}
+	api, err := r.Environment("api.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(api, "PORT=8080") {
+		t.Errorf("configured ports must not be overridden: %v", api)
+	}
+	if port, addr := r.instancePort([]string{"PORT=8080"}, 301); port != 0 || addr != "localhost:8080" {
+		t.Errorf("unexpected port for configured PORT: %d %q", port, addr)
+	}
+	if port, addr := r.instancePort(nil, 301); port != 5301 || addr != "localhost:5301" {
+		t.Errorf("unexpected port: %d %q", port, addr)
+	}
+	r.BasePort = 0
+	if port, addr := r.instancePort(nil, 301); port != 0 || addr != "" {
+		t.Errorf("ports must not be assigned without base port: %d %q", port, addr)
+	}
+	if _, err := r.Environment("missing"); err == nil {
+		t.Error("missing environment files must be errors")
+	}
+	if _, err := r.Environment("ghost"); !errors.Is(err, ErrUnknownProcessType) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunBuildsOrder(t *testing.T) {
+	dir := t.TempDir()
+	trace := filepath.Join(dir, "trace")
+	step := func(name string, after ...string) *ProcessType {
+		return &ProcessType{Name: name, Cmd: "echo " + name + " >> " + trace, After: after}
+	}
+	run := func(jobs int, procs ...*ProcessType) (bool, map[string]string, string) {
+		t.Helper()
+		os.Remove(trace)
+		r := New()
+		r.WorkDir = dir
+		r.BuildJobs = jobs
+		r.Processes = procs
+		for _, p := range procs {
+			r.Formation[p.Name] = 1
+		}
+		ok := r.runBuilds(context.Background(), "", "")
+		statuses := make(map[string]string)
+		for _, s := range r.Builds()[0].Steps {
+			statuses[s.Name] = s.Status
+		}
+		b, _ := os.ReadFile(trace)
+		return ok, statuses, strings.Join(strings.Fields(string(b)), " ")
+	}
+
+	ok, _, got := run(1, step("build-c"), step("build-a"), step("build-b"))
+	if !ok || got != "build-c build-a build-b" {
+		t.Errorf("sequential builds must run in order of declaration, got %q", got)
+	}
+	ok, _, got = run(1, step("build-a", "build-b"), step("build-b"))
+	if !ok || got != "build-b build-a" {
+		t.Errorf("after= must be honored, got %q", got)
+	}
+
+	failing := &ProcessType{Name: "build-fail", Cmd: "false"}
+	ok, statuses, _ := run(1, failing, step("build-a"))
+	if ok || statuses["build-a"] != BuildSkipped {
+		t.Errorf("sequential builds must skip the remaining steps after a failure: %v", statuses)
+	}
+	ok, statuses, got = run(4, failing, step("build-a", "build-fail"), step("build-b"), step("build-c", "build-a"))
+	if ok || statuses["build-a"] != BuildSkipped || statuses["build-c"] != BuildSkipped {
+		t.Errorf("parallel builds must skip the dependents of failed steps: %v", statuses)
+	}
+	if statuses["build-b"] != BuildDone || got != "build-b" {
+		t.Errorf("parallel builds must run independent steps: %v %q", statuses, got)
+	}
+}
+
+func TestRunBuildsJobsLimit(t *testing.T) {
+	dir := t.TempDir()
+	running := filepath.Join(dir, "running")
+	if err := os.Mkdir(running, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	trace := filepath.Join(dir, "trace")
+	cmd := "touch " + running + "/$$; ls " + running + " | wc -l >> " + trace + "; sleep 0.2; rm " + running + "/$$"
+	r := New()
+	r.WorkDir = dir
+	r.BuildJobs = 2
+	r.Processes = []*ProcessType{
+		{Name: "build-a", Cmd: cmd},
+		{Name: "build-b", Cmd: cmd},
+	}
+	r.Formation["build-a"] = 3
+	r.Formation["build-b"] = 1
+	if !r.runBuilds(context.Background(), "", "") {
+		t.Fatal("build failed")
+	}
+	b, _ := os.ReadFile(trace)
+	counts := strings.Fields(string(b))
+	if len(counts) != 4 {
+		t.Fatalf("all copies of the build steps must run: %q", counts)
+	}
+	for _, c := range counts {
+		if n, _ := strconv.Atoi(c); n > r.BuildJobs {
+			t.Errorf("build steps must not run more than %d copies at once: %q", r.BuildJobs, counts)
+		}
+	}
+	for _, s := range r.Builds()[0].Steps {
+		if s.Status != BuildDone {
+			t.Errorf("unexpected status for %s: %v", s.Name, s.Status)
+		}
+	}
+}
+
+func TestRunBuildsTimeoutAndRetries(t *testing.T) {
+	dir := t.TempDir()
+	run := func(procs ...*ProcessType) (bool, []*BuildStep) {
+		t.Helper()
+		r := New()
+		r.WorkDir = dir
+		r.Processes = procs
+		for _, p := range procs {
+			r.Formation[p.Name] = 1
+		}
+		ok := r.runBuilds(context.Background(), "", "")
+		return ok, r.Builds()[0].Steps
+	}
+
+	start := time.Now()
+	ok, steps := run(&ProcessType{Name: "build", Cmd: "trap '' TERM; sleep 10 & wait", Timeout: 200 * time.Millisecond})
+	if ok || len(steps) != 1 || steps[0].Status != BuildTimedOut {
+		t.Errorf("hung steps must time out: %+v", steps)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out steps must be killed, took %v", elapsed)
+	}
+
+	counter := filepath.Join(dir, "counter")
+	flaky := &ProcessType{
+		Name:       "build",
+		Cmd:        "echo . >> " + counter + "; test $(wc -l < " + counter + ") -ge 3",
+		Retries:    3,
+		RetryDelay: time.Millisecond,
+	}
+	ok, steps = run(flaky)
+	if !ok || len(steps) != 3 {
+		t.Fatalf("flaky steps must be retried until they succeed: %+v", steps)
+	}
+	for i, s := range steps {
+		want := BuildErrored
+		if i == 2 {
+			want = BuildDone
+		}
+		if s.Attempt != i+1 || s.Status != want {
+			t.Errorf("unexpected attempt record: %+v", s)
+		}
+	}
+
+	ok, steps = run(&ProcessType{Name: "build", Cmd: "false", Retries: 1, RetryDelay: time.Millisecond})
+	if ok || len(steps) != 2 {
+		t.Errorf("retries must be bounded: %+v", steps)
+	}
+}
+
+func TestBuildDiagnosticsInDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "web"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	r.WorkDir = dir
+	r.EditorURL = "vscode://file/{path}:{line}:{col}"
+	r.Processes = []*ProcessType{{
+		Name: "build-web",
+		Dir:  "web",
+		Cmd:  `printf 'src/app.ts(3,5): error TS2322: bad\n../shared/util.go:7:2: undefined: x\n'; false`,
+	}}
+	r.Formation["build-web"] = 1
+	if r.runBuilds(context.Background(), "", "") {
+		t.Fatal("build must fail")
+	}
+	var got []string
+	for _, d := range r.Diagnostics() {
+		got = append(got, d.File+" "+d.URL)
+	}
+	want := []string{
+		"web/src/app.ts vscode://file/" + filepath.Join(dir, "web/src/app.ts") + ":3:5",
+		"shared/util.go vscode://file/" + filepath.Join(dir, "shared/util.go") + ":7:2",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diagnostics must be relative to the working directory (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	run := func(procs ...*ProcessType) *Report {
+		t.Helper()
+		r := New()
+		r.WorkDir = t.TempDir()
+		r.Processes = procs
+		for _, p := range procs {
+			r.Formation[p.Name] = 1
+		}
+		rep, err := r.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep
+	}
+	statuses := func(rep *Report) map[string]string {
+		m := make(map[string]string)
+		for _, s := range rep.Steps {
+			m[s.Kind+":"+s.Name] = s.Status
+		}
+		return m
+	}
+
+	rep := run(
+		&ProcessType{Name: "build", Cmd: "true"},
+		&ProcessType{Name: "web", Cmd: "sleep 30"},
+		&ProcessType{Name: "test", Cmd: "true"},
+		&ProcessType{Name: "check", Cmd: "exit 3"},
+	)
+	got := statuses(rep)
+	want := map[string]string{
+		"build:build":   BuildDone,
+		"service:web.0": StateRunning,
+		"task:test.0":   BuildDone,
+		"task:check.0":  BuildErrored,
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("unexpected report: %v", cmp.Diff(want, got))
+	}
+	if !rep.Failed() {
+		t.Error("failed tasks must fail the run")
+	}
+	for _, s := range rep.Steps {
+		if s.Name == "check.0" && s.ExitCode != 3 {
+			t.Errorf("unexpected exit code: %v", s.ExitCode)
+		}
+	}
+
+	rep = run(
+		&ProcessType{Name: "build", Cmd: "false"},
+		&ProcessType{Name: "test", Cmd: "true"},
+	)
+	if got := statuses(rep)["task:test.0"]; got != BuildSkipped || !rep.Failed() {
+		t.Errorf("tasks must be skipped when builds fail, got %v", got)
+	}
+
+	rep = run(&ProcessType{Name: "test", Cmd: "true"})
+	if rep.Failed() {
+		t.Errorf("successful runs must not fail: %v", statuses(rep))
+	}
+}
+
+func TestHealth(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web"},
+		{Name: "worker"},
+		{Name: "migrate", Restart: Temporary},
+	}
+	r.Formation = map[string]int{"web": 1, "worker": 1, "migrate": 1}
+	if h := r.health(nil); h.Ready || h.Build != "" {
+		t.Errorf("must not be ready before the first build: %+v", h)
+	}
+	r.finishBuild(r.newBuild(""), true)
+	r.instance("web.0").setState(StateRunning)
+	r.instance("worker.0").setState(StateWaiting)
+	h := r.health(nil)
+	if h.Ready || len(h.Pending) != 1 || h.Pending[0].Name != "worker.0" {
+		t.Errorf("waiting instances must be pending, temporary ones ignored: %+v", h)
+	}
+	if h := r.health([]string{"web"}); !h.Ready {
+		t.Errorf("selected process types must be ready: %+v", h)
+	}
+	if h := r.health([]string{"worker.0"}); h.Ready {
+		t.Errorf("selected instances must be pending: %+v", h)
+	}
+	r.instance("worker.0").setState(StateExited)
+	if h := r.health(nil); !h.Ready {
+		t.Errorf("instances that exited successfully must not be pending: %+v", h)
+	}
+	r.instance("worker.0").setState(StateFailed)
+	if h := r.health(nil); h.Ready || len(h.Pending) != 1 || h.Pending[0].Name != "worker.0" {
+		t.Errorf("failed instances must be pending: %+v", h)
+	}
+	r.instance("worker.0").setState(StateRunning)
+	r.finishBuild(r.newBuild(""), false)
+	if h := r.health(nil); h.Ready || h.Build != BuildErrored {
+		t.Errorf("must not be ready after a failed build: %+v", h)
+	}
+}
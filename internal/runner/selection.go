@@ -0,0 +1,195 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Selection is the choice of the process types to run. Select applies
+// Profile, Skip, Optional and Only, in this order, on top of the formation,
+// so later ones win: optional process types start even if skipped, and Only
+// replaces the whole formation. Only, Skip and Optional list process type
+// names, or group names prefixed by @.
+type Selection struct {
+	Profile  string   `json:"profile,omitempty"`
+	Only     []string `json:"only,omitempty"`
+	Skip     []string `json:"skip,omitempty"`
+	Optional []string `json:"optional,omitempty"`
+
+	// ProcessTypes is the outcome of the selection, filled by Select.
+	ProcessTypes []SelectedProcessType `json:"processTypes"`
+}
+
+// SelectedProcessType tells how many instances of a process type run, and
+// which part of the selection decided it.
+type SelectedProcessType struct {
+	Name      string   `json:"name"`
+	Groups    []string `json:"groups,omitempty"`
+	Instances int      `json:"instances"`
+	Reason    string   `json:"reason"`
+}
+
+// ErrUnknownProfile is returned by Select for profiles that are not defined.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// ErrUnknownGroup is returned by Select for groups no process type belongs to.
+var ErrUnknownGroup = errors.New("unknown group")
+
+// Select changes the formation according to the selection, and records the
+// reason behind the number of instances of each process type. In order: the
+// profile replaces the formation; skipped process types do not start;
+// optional ones start one instance; and when Only is set, the formation is
+// reset, so only the process types it lists start, with one instance each.
+func (r *Runner) Select(sel Selection) error {
+	reasons := make(map[string]string, len(r.Processes))
+	for _, sv := range r.Processes {
+		reasons[sv.Name] = "formation"
+		if r.Formation[sv.Name] == 0 {
+			reasons[sv.Name] = "not in formation"
+		}
+	}
+	if sel.Profile != "" {
+		profile, ok := r.Profiles[sel.Profile]
+		if !ok {
+			return fmt.Errorf("%w %q, available: %s", ErrUnknownProfile, sel.Profile, strings.Join(slices.Sorted(maps.Keys(r.Profiles)), ", "))
+		}
+		formation := make(map[string]int, len(profile))
+		for _, sv := range r.Processes {
+			reasons[sv.Name] = "not in profile " + sel.Profile
+		}
+		for _, selector := range slices.Sorted(maps.Keys(profile)) {
+			names, err := r.selectProcessTypes(selector)
+			if err != nil {
+				return fmt.Errorf("profile %s: %w", sel.Profile, err)
+			}
+			for _, name := range names {
+				formation[name] = profile[selector]
+				reasons[name] = "profile " + sel.Profile
+			}
+		}
+		r.Formation = formation
+	}
+	apply := func(selectors []string, count int, reason string) error {
+		for _, selector := range selectors {
+			names, err := r.selectProcessTypes(selector)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				r.Formation[name] = count
+				reasons[name] = reason
+				if selector != name {
+					reasons[name] += " " + selector
+				}
+			}
+		}
+		return nil
+	}
+	if err := apply(sel.Skip, 0, "skip"); err != nil {
+		return err
+	}
+	if err := apply(sel.Optional, 1, "optional"); err != nil {
+		return err
+	}
+	if len(sel.Only) > 0 {
+		r.Formation = make(map[string]int, len(sel.Only))
+		for _, sv := range r.Processes {
+			reasons[sv.Name] = "not listed in only"
+		}
+		if err := apply(sel.Only, 1, "only"); err != nil {
+			return err
+		}
+	}
+	sel.ProcessTypes = nil
+	for _, sv := range r.Processes {
+		sel.ProcessTypes = append(sel.ProcessTypes, SelectedProcessType{
+			Name:      sv.Name,
+			Groups:    sv.Groups,
+			Instances: r.Formation[sv.Name],
+			Reason:    reasons[sv.Name],
+		})
+	}
+	r.selection = &sel
+	return nil
+}
+
+// selectProcessTypes lists the process types named by selector, either a
+// process type name or a group name prefixed by @.
+func (r *Runner) selectProcessTypes(selector string) ([]string, error) {
+	group, isGroup := strings.CutPrefix(selector, "@")
+	if !isGroup {
+		return []string{selector}, nil
+	}
+	var names []string
+	for _, sv := range r.Processes {
+		if slices.Contains(sv.Groups, group) {
+			names = append(names, sv.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrUnknownGroup, group)
+	}
+	return names, nil
+}
+
+// Selection returns the outcome of the last call to Select, if any.
+func (r *Runner) Selection() (Selection, bool) {
+	if r.selection == nil {
+		return Selection{}, false
+	}
+	return *r.selection, true
+}
+
+// logSelection announces which process types start, and why the others do
+// not.
+func (r *Runner) logSelection() {
+	sel, ok := r.Selection()
+	if !ok {
+		return
+	}
+	var criteria []string
+	if sel.Profile != "" {
+		criteria = append(criteria, "profile "+sel.Profile)
+	}
+	for _, c := range []struct {
+		name      string
+		selectors []string
+	}{{"only", sel.Only}, {"skip", sel.Skip}, {"optional", sel.Optional}} {
+		if len(c.selectors) > 0 {
+			criteria = append(criteria, c.name+" "+strings.Join(c.selectors, ","))
+		}
+	}
+	var starting []string
+	for _, sv := range sel.ProcessTypes {
+		if sv.Instances > 0 {
+			starting = append(starting, fmt.Sprintf("%s:%d", sv.Name, sv.Instances))
+		}
+	}
+	if len(criteria) > 0 {
+		log.Println("selection:", strings.Join(criteria, "; "))
+	}
+	log.Println("starting:", strings.Join(starting, " "))
+	for _, sv := range sel.ProcessTypes {
+		if sv.Instances == 0 {
+			log.Printf("not starting %s: %s", sv.Name, sv.Reason)
+		}
+	}
+}
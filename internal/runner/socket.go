@@ -0,0 +1,149 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"cirello.io/runner/v3/internal/shell"
+)
+
+// socket is a listening socket owned by the runner on behalf of a process
+// instance. It outlives the instance restarts, so incoming connections queue
+// in the backlog instead of being refused while the process is down.
+type socket struct {
+	listener *net.TCPListener
+	file     *os.File
+	port     int
+}
+
+// socketAddress interprets the socket option of a process type. "tcp" binds
+// an ephemeral port on localhost; "tcp:port" and "tcp:host:port" bind the
+// given address, offset by the instance number, so each copy in the
+// formation gets its own port.
+func socketAddress(spec string, procCount int) (string, error) {
+	network, addr, _ := strings.Cut(spec, ":")
+	if network != "tcp" {
+		return "", fmt.Errorf("unsupported socket type %q", network)
+	}
+	if addr == "" {
+		return "localhost:0", nil
+	}
+	if !strings.Contains(addr, ":") {
+		addr = "localhost:" + addr
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid socket address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid socket port %q: %w", portStr, err)
+	}
+	if port != 0 {
+		port += procCount
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// socket returns the listening socket of the named instance, opening it on
+// first use.
+func (r *Runner) socket(sv *ProcessType, procCount int) (*socket, error) {
+	name := instanceName(sv.Name, procCount)
+	r.socketsMu.Lock()
+	defer r.socketsMu.Unlock()
+	if s, ok := r.sockets[name]; ok {
+		return s, nil
+	}
+	addr, err := socketAddress(sv.Socket, procCount)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen: %w", err)
+	}
+	tcpListener := l.(*net.TCPListener)
+	f, err := tcpListener.File()
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("cannot obtain socket file: %w", err)
+	}
+	s := &socket{
+		listener: tcpListener,
+		file:     f,
+		port:     tcpListener.Addr().(*net.TCPAddr).Port,
+	}
+	log.Println("listening on", l.Addr(), "for", name)
+	r.sockets[name] = s
+	return s, nil
+}
+
+func (r *Runner) closeSockets() {
+	r.socketsMu.Lock()
+	defer r.socketsMu.Unlock()
+	for name, s := range r.sockets {
+		s.file.Close()
+		s.listener.Close()
+		delete(r.sockets, name)
+	}
+}
+
+// shellBuiltins are the words that start commands exec cannot replace the
+// shell with, as they are not programs.
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "alias": true, "break": true, "case": true,
+	"cd": true, "continue": true, "eval": true, "exec": true, "exit": true,
+	"export": true, "for": true, "if": true, "read": true, "readonly": true,
+	"return": true, "set": true, "shift": true, "source": true, "trap": true,
+	"ulimit": true, "umask": true, "unset": true, "until": true, "wait": true,
+	"while": true, "{": true, "!": true,
+}
+
+// execInPlace makes the shell replace itself with the program of a simple
+// command, so that the program keeps the PID of the shell. Leading variable
+// assignments are kept before exec, which passes them on to the program.
+// Commands that start with a shell builtin or keyword are left as they are.
+func execInPlace(cmd string) string {
+	for _, w := range shell.Fields(cmd) {
+		if isAssignment(w.Raw) {
+			continue
+		}
+		if shellBuiltins[w.Raw] || strings.HasPrefix(w.Raw, "(") {
+			return cmd
+		}
+		return cmd[:w.Start] + "exec " + cmd[w.Start:]
+	}
+	return cmd
+}
+
+// isAssignment tells whether the shell word assigns a variable, like FOO=bar.
+func isAssignment(word string) bool {
+	name, _, found := strings.Cut(word, "=")
+	if !found || name == "" || '0' <= name[0] && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
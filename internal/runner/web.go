@@ -16,7 +16,7 @@ package runner
 
 import (
 	"context"
-	_ "embed"
+	"embed"
 	"encoding/json"
 	"errors"
 	"html/template"
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
 
 	terminal "github.com/buildkite/terminal-to-html/v3"
@@ -78,17 +79,65 @@ func (r *Runner) serveWeb(ctx context.Context) error {
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		sseURL := url.URL{Scheme: "http", Host: req.Host, Path: "/logs"}
 		query := sseURL.Query()
-		query.Set("model", "html")
+		query.Set("mode", "html")
 		filter := req.URL.Query().Get("filter")
 		if filter != "" {
 			query.Set("filter", filter)
 		}
 		sseURL.RawQuery = query.Encode()
-		logsPage.Execute(w, struct {
+		dashboardPage.Execute(w, struct {
 			URL    string
 			Filter string
 		}{sseURL.String(), filter})
 	})
+	mux.Handle("GET /assets/", http.FileServerFS(assets))
+	mux.HandleFunc("GET /processes", func(w http.ResponseWriter, _ *http.Request) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(r.processStates()); err != nil {
+			log.Println("cannot serve process states request:", err)
+		}
+	})
+	mux.HandleFunc("POST /processes/{name}/{action}", r.controlProcess)
+	mux.HandleFunc("GET /builds", func(w http.ResponseWriter, _ *http.Request) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(r.Builds()); err != nil {
+			log.Println("cannot serve builds request:", err)
+		}
+	})
+	mux.HandleFunc("GET /builds/{id}", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid build id", http.StatusBadRequest)
+			return
+		}
+		build, ok := r.BuildByID(id)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(build); err != nil {
+			log.Println("cannot serve build request:", err)
+		}
+	})
+	mux.HandleFunc("GET /diagnostics", func(w http.ResponseWriter, _ *http.Request) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(r.Diagnostics()); err != nil {
+			log.Println("cannot serve diagnostics request:", err)
+		}
+	})
+	mux.HandleFunc("GET /selection", func(w http.ResponseWriter, _ *http.Request) {
+		sel, _ := r.Selection()
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(sel); err != nil {
+			log.Println("cannot serve selection request:", err)
+		}
+	})
 	mux.HandleFunc("/state", func(w http.ResponseWriter, _ *http.Request) {
 		enc := json.NewEncoder(w)
 		enc.SetIndent("", "    ")
@@ -132,6 +181,12 @@ func (r *Runner) serveWeb(ctx context.Context) error {
 			}
 		}
 	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, req *http.Request) {
+		r.streamEvents(w, req)
+	})
+	r.serveLiveReload(mux)
+	r.serveBadges(mux)
+	r.serveHealth(mux)
 	server := &http.Server{
 		Addr:    ":0",
 		Handler: mux,
@@ -148,8 +203,92 @@ func (r *Runner) serveWeb(ctx context.Context) error {
 	return nil
 }
 
+// streamEvents forwards the runner events of the given types, or all of them
+// if no type is given, as server-sent events.
+func (r *Runner) streamEvents(w http.ResponseWriter, req *http.Request, types ...string) {
+	stream := r.subscribeEvents()
+	defer r.unsubscribeEvents(stream)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case ev := <-stream:
+			if len(types) > 0 && !slices.Contains(types, ev.Type) {
+				continue
+			}
+			b, err := json.Marshal(ev)
+			if err != nil {
+				log.Println("encode:", err)
+				return
+			}
+			_, err = w.Write([]byte("data: " + string(b) + "\n\n"))
+			if err != nil {
+				log.Println("write:", err)
+				return
+			}
+			w.(http.Flusher).Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
 var (
-	//go:embed logs.tpl
-	logsPageTPL string
-	logsPage    = template.Must(template.New("").Parse(logsPageTPL))
+	//go:embed assets
+	assets        embed.FS
+	dashboardPage = template.Must(template.ParseFS(assets, "assets/dashboard.tpl"))
 )
+
+// controlProcess restarts, stops or starts a process instance. Requests sent
+// by browsers from other origins are refused, so that web pages cannot
+// control the processes through the service discovery address.
+func (r *Runner) controlProcess(w http.ResponseWriter, req *http.Request) {
+	if !sameOrigin(req) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	name := req.PathValue("name")
+	if !slices.ContainsFunc(r.processStates(), func(ps ProcessState) bool {
+		return ps.Name == name
+	}) {
+		http.NotFound(w, req)
+		return
+	}
+	inst := r.instance(name)
+	var err error
+	action := req.PathValue("action")
+	switch action {
+	case "restart":
+		err = inst.restart("")
+	case "stop":
+		err = inst.stop()
+	case "start":
+		err = inst.start()
+	default:
+		http.Error(w, "unknown action: "+action, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "cannot "+action+" "+name+": "+err.Error(), http.StatusConflict)
+		return
+	}
+	log.Println(action, name, "requested from", req.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sameOrigin tells whether the request was not sent by a browser on behalf of
+// another origin. Requests without Origin, like the ones of curl, are
+// accepted.
+func sameOrigin(req *http.Request) bool {
+	if site := req.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
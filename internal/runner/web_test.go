@@ -0,0 +1,141 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// externalOrigin matches absolute and protocol-relative URLs.
+var externalOrigin = regexp.MustCompile(`(?i)(https?:)?//[a-z0-9-]+(\.[a-z0-9-]+)+`)
+
+func TestNoExternalOrigins(t *testing.T) {
+	files := map[string][]byte{"livereload.js": liveReloadJS}
+	err := fs.WalkDir(assets, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(assets, path)
+		files[path] = b
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		if found := externalOrigin.Find(content); found != nil {
+			t.Errorf("%s references an external origin: %s", path, found)
+		}
+	}
+}
+
+func TestRenderBadge(t *testing.T) {
+	badge := renderBadge(`<build&"server">`, "errored")
+	dec := xml.NewDecoder(bytes.NewReader(badge))
+	var texts []string
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("badge is not well-formed: %v\n%s", err, badge)
+		}
+		if cd, ok := tok.(xml.CharData); ok && len(bytes.TrimSpace(cd)) > 0 {
+			texts = append(texts, string(cd))
+		}
+	}
+	if len(texts) != 3 || texts[1] != `<build&"server">` || texts[2] != "errored" {
+		t.Errorf("unexpected badge texts: %q", texts)
+	}
+	if !bytes.Contains(badge, []byte(badgeColor("errored"))) {
+		t.Error("badge is missing the status color")
+	}
+}
+
+func TestControlProcessOrigin(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{{Name: "web"}}
+	r.Formation = map[string]int{"web": 1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /processes/{name}/{action}", r.controlProcess)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"no origin", nil, http.StatusNoContent},
+		{"same origin", map[string]string{"Origin": "http://localhost:64000", "Sec-Fetch-Site": "same-origin"}, http.StatusNoContent},
+		{"cross origin", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"null origin", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross site fetch", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://localhost:64000/processes/web.0/stop", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("unexpected status: %d, expected %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlProcessConflict(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{{Name: "migrate", Restart: Temporary}, {Name: "web"}}
+	r.Formation = map[string]int{"migrate": 1, "web": 1}
+	r.instance("migrate.0").exited(true)
+	r.instance("web.0").activate()
+	r.instance("web.0").setState(StateRunning)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /processes/{name}/{action}", r.controlProcess)
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/processes/migrate.0/restart", http.StatusConflict},
+		{"/processes/migrate.0/stop", http.StatusConflict},
+		{"/processes/migrate.0/start", http.StatusConflict},
+		{"/processes/web.0/start", http.StatusConflict},
+		{"/processes/web.0/stop", http.StatusNoContent},
+		{"/processes/web.0/start", http.StatusNoContent},
+		{"/processes/web.0/restart", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost:64000"+tt.url, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: unexpected status: %d, expected %d", tt.url, w.Code, tt.want)
+		}
+	}
+	if _, restart := r.instance("migrate.0").takeRestart(); restart {
+		t.Error("refused restarts must not be recorded")
+	}
+	if _, restart := r.instance("web.0").takeRestart(); !restart {
+		t.Error("restarts of running instances must be recorded")
+	}
+}
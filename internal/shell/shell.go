@@ -0,0 +1,130 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shell splits commands into words following the quoting rules of
+// the POSIX shell, as far as the runner needs to understand them.
+package shell
+
+import "strings"
+
+// Word is a shell word. Raw is the text as written, between the Start and End
+// offsets; Text has the quotes and escapes removed.
+type Word struct {
+	Raw, Text  string
+	Start, End int
+}
+
+// Fields splits s into words following the quoting rules of the shell:
+// blanks and newlines separate words, backslashes escape the next character
+// and are removed along with the newline of line continuations, single quotes
+// preserve their content, and double quotes preserve their content except for
+// backslash escapes of ", \, $ and `. Variable references like ${VAR:-a b}
+// are part of a single word. Unterminated quotes extend to the end of s.
+func Fields(s string) []Word {
+	words, _ := split(s)
+	return words
+}
+
+// Simple tells whether s is a single simple command, that is, whether it has
+// no unquoted control operators: ;, &, | or newlines other than line
+// continuations. Redirections like 2>&1 are not control operators.
+func Simple(s string) bool {
+	_, control := split(s)
+	return !control
+}
+
+// split implements Fields, and reports whether s has unquoted control
+// operators.
+func split(s string) ([]Word, bool) {
+	var (
+		words   []Word
+		control bool
+	)
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "\\\n") {
+			i += 2
+			continue
+		}
+		if s[i] == ' ' || s[i] == '\t' || s[i] == '\n' {
+			control = control || s[i] == '\n'
+			i++
+			continue
+		}
+		start := i
+		var text strings.Builder
+	scan:
+		for ; i < len(s); i++ {
+			switch c := s[i]; c {
+			case ' ', '\t', '\n':
+				break scan
+			case ';', '&', '|':
+				// >& and <& duplicate file descriptors.
+				if c != '&' || i == 0 || (s[i-1] != '>' && s[i-1] != '<') {
+					control = true
+				}
+				text.WriteByte(c)
+			case '\\':
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				} else if i+1 < len(s) {
+					i++
+					text.WriteByte(s[i])
+				}
+			case '\'':
+				end := strings.IndexByte(s[i+1:], '\'')
+				if end < 0 {
+					end = len(s) - i - 1
+				}
+				text.WriteString(s[i+1 : i+1+end])
+				i += end + 1
+			case '$':
+				if end := MatchingBrace(s, i+1); i+1 < len(s) && s[i+1] == '{' && end > 0 {
+					text.WriteString(s[i : end+1])
+					i = end
+				} else {
+					text.WriteByte(c)
+				}
+			case '"':
+				for i++; i < len(s) && s[i] != '"'; i++ {
+					if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+						i++
+					}
+					text.WriteByte(s[i])
+				}
+			default:
+				text.WriteByte(c)
+			}
+		}
+		end := min(i, len(s))
+		words = append(words, Word{Raw: s[start:end], Text: text.String(), Start: start, End: end})
+	}
+	return words, control
+}
+
+// MatchingBrace finds the brace that closes the one at open.
+func MatchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
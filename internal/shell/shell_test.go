@@ -0,0 +1,59 @@
+// Copyright 2024 github.com/ucirello, cirello.io, U. Cirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shell
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFields(t *testing.T) {
+	got := Fields(`A="x y" grep 'a|b' \
+	${VAR:-a b} c\ d`)
+	want := []Word{
+		{Raw: `A="x y"`, Text: "A=x y", Start: 0, End: 7},
+		{Raw: "grep", Text: "grep", Start: 8, End: 12},
+		{Raw: "'a|b'", Text: "a|b", Start: 13, End: 18},
+		{Raw: "${VAR:-a b}", Text: "${VAR:-a b}", Start: 22, End: 33},
+		{Raw: `c\ d`, Text: "c d", Start: 34, End: 38},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Fields() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSimple(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"./server --flag", true},
+		{"grep 'a|b' file", true},
+		{`echo "a;b" a\&b`, true},
+		{"./server 2>&1", true},
+		{"./server \\\n  --flag", true},
+		{"./a; ./b", false},
+		{"./a && ./b", false},
+		{"./a | ./b", false},
+		{"./a &", false},
+		{"./a\n./b", false},
+	}
+	for _, tt := range tests {
+		if got := Simple(tt.cmd); got != tt.want {
+			t.Errorf("Simple(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
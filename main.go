@@ -27,10 +27,19 @@ the following content:
 	web-b: restart=onbuild waitfor=localhost:8888 ./server serve bravo
 	db: restart=failure waitfor=localhost:8888 ./server db
 
+Options of process types are the key=value words that precede the command, or
+that follow it after a -- separator when every word after it is an option. The
+rest of the line is the command, passed to the shell exactly as written.
+Lines ending with a backslash continue on the next line, and a process type
+declared without a command runs the lines indented under it as a script.
+
+Directives and option values can refer to variables from the environment and
+the --env file as $VAR, ${VAR}, ${VAR:-default} or ${VAR:?message}.
+
 Special process types:
 
-- workdir: the working directory. Environment variables are expanded. It follows
-the same rules for exec.Command.Dir.
+- workdir: the working directory. It follows the same rules for
+exec.Command.Dir.
 
 - observe: a space separated list of file patterns to scan for. It uses
 filepath.Match internally. File patterns preceded with exclamation mark (!) will
@@ -43,8 +52,58 @@ typically vendor directories.
 started, format: procTypeA:# procTypeB:# ... procTypeN:#. If `procType` is
 absent, it is not started. Empty formations start one of each process.
 
+- profile.<name>: a named formation, started with --profile name instead of the
+formation. Its entries can refer to the process types of a group as @group,
+e.g. profile.frontend-only: @frontend:1 api:1.
+
+- include: reads the process types and directives of other Procfiles in place,
+format: [optional=]path. Relative paths are resolved from the directory of the
+including file, and glob patterns include every matching file in lexical order,
+e.g. include: procfiles.d/*.procfile. Included files can include others; cycles
+are reported as errors. Missing files are errors unless prefixed with optional=.
+
+- remove: in overlays (Procfile.local or --overlay), a space separated list of
+process types of the Procfile to drop.
+
+- proxy: starts a HTTP reverse proxy in front of the process types, format:
+addr [hold=duration] [host][/path]=procType ... Requests are routed to the most
+specific match, by host and then by longest path prefix, matched on whole path
+segments (/api matches /api/v1 but not /apix), and round-robin across the
+running instances of the process type. While no instance accepts
+connections, for instance during a rebuild, requests are held for up to the
+hold duration (default 30s) instead of being refused. The access log flows
+with the output of the processes under the name "proxy". It routes to the
+PORT of each instance, so process types behind it must listen on it. Example:
+proxy: localhost:8080 api.localhost=api /static=assets /=web
+
 - build*: process type name prefixed by "build" are always executed first and in
-order of declaration. On failure, they halt the initialization.
+order of declaration. On failure, they halt the initialization and the
+remaining build steps are skipped. With --build-jobs N, up to N build steps run
+at the same time, and steps only wait for the ones declared in their after=
+option.
+
+- test*, check*: process types prefixed by "test" or "check" run as any other
+process type, except in one-shot runs (runner ci or --once), where they run to
+completion after the other process types are running.
+
+- after (in build process type): comma separated list of build steps that must
+succeed before this one starts, e.g. after=build-codegen. If any of them fails,
+this step is skipped, and shows up as "skipped" in the build state and history.
+
+- group (in process type): comma separated list of groups the process type
+belongs to, e.g. group=backend,db. Profiles, --only, --skip and --optional
+refer to the process types of a group as @group.
+
+- env (in process type): KEY=VALUE variable set for the process type only,
+e.g. env=NODE_ENV=development. It can be repeated, and values can be quoted:
+env="OPTS=-a -b".
+
+- envfile (in process type): comma separated list of environment files,
+relative to workdir, read every time the process type starts, e.g.
+envfile=frontend/.env,frontend/.env.local. It can be repeated.
+
+- dir (in process type): directory, relative to workdir, in which the process
+type runs, e.g. dir=frontend.
 
 - waitfor (in process type): target hostname and port that the runner will probe
 before starting the process type.
@@ -52,6 +111,51 @@ before starting the process type.
 - restart (in process type): "onbuild" will restart the process type at every
 build; "fail" will restart the process type on failure; "loop" restart the
 process when it naturally terminates; "temporary" runs the process only once.
+
+- outputs (in build process type): comma separated list of files produced by
+the build step, e.g. outputs=bin/server,bin/worker. As a space ends the value of
+an option, lists are comma separated rather than space separated. After every
+successful build, the runner hashes them to detect which ones changed.
+
+- timeout (in process type): how long the runner waits after sending the stop
+signal before killing the process group, e.g. timeout=10s. In build process
+types, it is the maximum duration of the step, e.g. timeout=2m; when it
+expires, the process group of the step is killed and the step is marked as
+"timed-out".
+
+- signal (in process type): signal delivered to the process group to stop it,
+e.g. signal=SIGINT. The default is SIGTERM.
+
+- retries (in build process type): number of times a failed or timed out build
+step is executed again before the build fails, e.g. retries=2. Attempts are
+separated by retry-delay (default 1s), and each of them is recorded in the
+build history and announced in the log stream.
+
+- consumes (in process type): comma separated list of build outputs the process
+type runs, e.g. consumes=bin/server. Such process types are only restarted
+after a build when one of their consumed outputs changed; otherwise they keep
+running with their connections and in-memory state.
+
+- reload (in process type): signal, e.g. SIGHUP, delivered to the process group
+instead of restarting it when only files matching reload-on change. Each
+delivery is logged and broadcasted as a "reload" event through the /events
+endpoint of the service discovery server.
+
+- reload-on (in process type): comma separated list of file patterns that
+trigger a reload instead of a build, e.g. reload-on=*.yaml,conf/*.toml.
+Files that the observe patterns also match trigger both the reload and a
+build.
+
+- socket (in process type): "tcp", "tcp:port" or "tcp:host:port" makes the
+runner own a listening socket for each instance, which stays open across
+restarts so connections queue instead of being refused. The socket is passed as
+file descriptor 3 following the systemd socket activation protocol (LISTEN_FDS,
+LISTEN_PID and LISTEN_FDNAMES), and its port is exported as PORT. The port is
+offset by the instance number; "tcp" alone picks an ephemeral port. LISTEN_PID
+only matches single commands, optionally preceded by variable assignments like
+FOO=bar ./server, which the runner execs in place; commands chained with ;, &&,
+|| or pipes, and commands starting with a shell builtin like cd, see the PID of
+the intermediate shell.
 */
 package main // import "cirello.io/runner/v3"
 
@@ -68,12 +172,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"cirello.io/runner/v3/internal/envfile"
 	"cirello.io/runner/v3/internal/procfile"
@@ -82,6 +190,10 @@ import (
 
 const defaultProcfile = "Procfile"
 
+// defaultProxyBasePort is the first port assigned to process instances when
+// the Procfile has a proxy directive and --base-port is not set.
+const defaultProxyBasePort = 5000
+
 func main() {
 	var version string
 	if info, ok := debug.ReadBuildInfo(); ok {
@@ -106,10 +218,21 @@ func main() {
 	flagset.String("service-discovery", "localhost:64000", "service discovery address")
 	flagset.String("formation", "", "formation allows to control how many instances of a process type are started, format: `procTypeA:# procTypeB:# ... procTypeN:#`. If `procType` is absent, it is not started. Empty formations start one of each process.")
 	flagset.String("env", ".env", "environment `file` to be loaded for all processes, if the file is absent, then this parameter is ignored.")
-	flagset.String("skip", "", "does not run some of the process types, format: `procTypeA procTypeB procTypeN`")
-	flagset.String("only", "", "only runs some of the process types, format: `procTypeA procTypeB procTypeN`")
-	flagset.String("optional", "", "forcefully runs some of the process types, format: `procTypeA procTypeB procTypeN`")
+	flagset.String("profile", "", "`name` of the profile.<name> directive of the Procfile used as formation")
+	flagset.String("skip", "", "does not run some of the process types, format: `procTypeA @groupB procTypeN`")
+	flagset.String("only", "", "only runs some of the process types, format: `procTypeA @groupB procTypeN`")
+	flagset.String("optional", "", "forcefully runs some of the process types, format: `procTypeA @groupB procTypeN`")
 	flagset.String("filter", "", "service name to filter message")
+	flagset.Int("base-port", 0, "first `port` assigned to process instances through the PORT environment variable, each instance gets its own port. PORT set in the --env file or in the env and envfile options is kept. With 0, ports are only assigned when the Procfile has a proxy directive, starting from 5000.")
+	flagset.String("editor-url", "", "`template` of the links that open build diagnostics in an editor, {path} is replaced by the absolute path of the file, {file} by its path relative to workdir, {line} and {col} by the position. Example: vscode://file{path}:{line}:{col}")
+	flagset.Bool("livereload", false, "inject the live reload script into the HTML pages served by the proxy, so browsers reload after successful rebuilds")
+	flagset.Bool("strict", false, "refuse to start when the Procfile has errors, as reported by the check command")
+	flagset.Bool("once", false, "run the build steps, start the processes, run the test* and check* process types to completion, then exit with a non-zero status if any of them failed. Same as the ci command.")
+	flagset.Var(&overlayFlag{}, "overlay", "`file` applied on top of the Procfile, to override process types, remove them or patch the formation. It can be repeated. Without it, Procfile.local is applied when present next to the Procfile.")
+	flagset.Var(&reportFlag{}, "report", "write the results of --once in `format:file`, where format is junit or json. It can be repeated, e.g. --report junit:out.xml --report json:out.json")
+	flagset.Duration("ready-timeout", 2*time.Minute, "how long --once waits for the processes to be running before giving up")
+	flagset.Int("build-jobs", 1, "maximum `number` of build steps executed at the same time. With 1, they run in order of declaration and a failure skips the remaining steps; with more, steps only wait for the ones they declare in after=.")
+	flagset.Bool("build-cache", false, "skip build steps and restarts when the content of the observed files, the command and the environment are unchanged since the last successful build. The cache is kept in the .runner directory inside workdir.")
 	if err := flagset.Parse(os.Args[1:]); err == flag.ErrHelp {
 		return
 	} else if err != nil {
@@ -122,6 +245,70 @@ func main() {
 		}
 		return
 	}
+	if once := flagset.Lookup("once").Value.String() == "true"; once || flagset.Arg(0) == "ci" {
+		fn := flagset.Arg(0)
+		if !once {
+			fn = flagset.Arg(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), haltSignals()...)
+		ok, err := ci(ctx, flagset, fn)
+		stop()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if flagset.Arg(0) == "check" {
+		ok, err := check(flagset, flagset.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if flagset.Arg(0) == "fmt" {
+		ok, err := format(flagset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if flagset.Arg(0) == "config" {
+		err := config(flagset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flagset.Arg(0) == "env" {
+		err := env(flagset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flagset.Arg(0) == "wait" {
+		err := wait(flagset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flagset.Arg(0) == "ps" {
+		err := ps(flagset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	interceptStdout()
 	ctx, stop := signal.NotifyContext(context.Background(), haltSignals()...)
 	defer stop()
@@ -181,16 +368,303 @@ func mainRunner(ctx context.Context, flagset *flag.FlagSet) error {
 	if argFn := flagset.Arg(0); argFn != "" {
 		fn = argFn
 	}
-	fd, err := os.Open(fn)
+	s, err := loadRunner(flagset, fn)
 	if err != nil {
 		return err
 	}
-	s, err := procfile.Parse(fd)
+	if err := s.Start(ctx); err != nil {
+		return fmt.Errorf("cannot serve: %v", err)
+	}
+	return nil
+}
+
+// ci runs the Procfile once and reports whether all steps succeeded.
+func ci(ctx context.Context, flagset *flag.FlagSet, fn string) (bool, error) {
+	if fn == "" {
+		fn = defaultProcfile
+	}
+	s, err := loadRunner(flagset, fn)
+	if err != nil {
+		return false, err
+	}
+	s.ReadyTimeout, _ = time.ParseDuration(flagset.Lookup("ready-timeout").Value.String())
+	rep, err := s.RunOnce(ctx)
+	if err != nil {
+		return false, fmt.Errorf("cannot run: %v", err)
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tKIND\tSTATUS\tEXIT CODE\tDURATION")
+	for _, step := range rep.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", step.Name, step.Kind, step.Status, step.ExitCode, step.Duration.Round(time.Millisecond))
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+	for _, report := range *flagset.Lookup("report").Value.(*reportFlag) {
+		format, fn, _ := strings.Cut(report, ":")
+		if err := writeReport(rep, format, fn); err != nil {
+			return false, fmt.Errorf("cannot write %s report: %v", format, err)
+		}
+	}
+	return !rep.Failed(), nil
+}
+
+// reportFlag collects the reports requested with --report.
+type reportFlag []string
+
+func (f *reportFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *reportFlag) Set(s string) error {
+	format, fn, found := strings.Cut(s, ":")
+	if !found || fn == "" {
+		return fmt.Errorf("invalid report %q, expected format:file", s)
+	}
+	if format != "junit" && format != "json" {
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+func writeReport(rep *runner.Report, format, fn string) error {
+	fd, err := os.Create(fn)
 	if err != nil {
-		return fmt.Errorf("cannot parse spec file (procfile): %v", err)
+		return err
+	}
+	write := rep.WriteJSON
+	if format == "junit" {
+		write = rep.WriteJUnit
+	}
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// check lints the Procfile and its overlays, and reports whether they are free
+// of errors.
+func check(flagset *flag.FlagSet, fn string) (bool, error) {
+	if fn == "" {
+		fn = defaultProcfile
+	}
+	env, err := loadEnv(flagset)
+	if err != nil {
+		return false, err
+	}
+	problems, err := procfile.Check(fn, env, overlays(flagset, fn)...)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return !procfile.HasErrors(problems), nil
+}
+
+// format rewrites Procfiles in their canonical form. With -l or -d, it
+// reports whether they were already formatted instead of rewriting them.
+func format(flagset *flag.FlagSet) (bool, error) {
+	fmtFlags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := fmtFlags.Bool("l", false, "list the files whose formatting differs from the canonical one")
+	diff := fmtFlags.Bool("d", false, "display diffs instead of rewriting files")
+	if err := fmtFlags.Parse(flagset.Args()[1:]); err != nil {
+		return false, err
+	}
+	files := fmtFlags.Args()
+	if len(files) == 0 {
+		files = []string{defaultProcfile}
+	}
+	ok := true
+	for _, fn := range files {
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			return false, err
+		}
+		out, err := procfile.Format(fn, src)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(src, out) {
+			continue
+		}
+		if !*list && !*diff {
+			if err := os.WriteFile(fn, out, 0o644); err != nil {
+				return false, err
+			}
+			continue
+		}
+		ok = false
+		if *list {
+			fmt.Println(fn)
+		}
+		if *diff {
+			d, err := unifiedDiff(fn, src, out)
+			if err != nil {
+				return false, err
+			}
+			os.Stdout.Write(d)
+		}
+	}
+	return ok, nil
+}
+
+// unifiedDiff compares the original and the formatted content of fn with the
+// diff command.
+func unifiedDiff(fn string, src, out []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "runner-fmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	orig, formatted := filepath.Join(dir, "orig"), filepath.Join(dir, "formatted")
+	if err := os.WriteFile(orig, src, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(formatted, out, 0o600); err != nil {
+		return nil, err
+	}
+	d, err := exec.Command("diff", "-u", "--label", fn+".orig", "--label", fn, orig, formatted).Output()
+	if len(d) > 0 {
+		// diff exits with status 1 when the files differ.
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot compute diff: %v", err)
+}
+
+type overlayFlag []string
+
+func (f *overlayFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *overlayFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// overlays lists the overlays given with --overlay, or the Procfile.local
+// next to the Procfile.
+func overlays(flagset *flag.FlagSet, fn string) []string {
+	files := *flagset.Lookup("overlay").Value.(*overlayFlag)
+	if len(files) == 0 {
+		local := fn + ".local"
+		if _, err := os.Stat(local); err == nil {
+			files = append(files, local)
+		}
+	}
+	return files
+}
+
+// resolveConfig reads the Procfile and applies its overlays.
+func resolveConfig(flagset *flag.FlagSet, fn string) (*procfile.Config, error) {
+	return procfile.Resolve(fn, overlays(flagset, fn)...)
+}
+
+// config prints the files that make up the configuration, or with
+// --resolved, the merged configuration with the origin of each entry.
+func config(flagset *flag.FlagSet) error {
+	configFlags := flag.NewFlagSet("config", flag.ContinueOnError)
+	resolved := configFlags.Bool("resolved", false, "print the configuration merged from the Procfile, its includes and its overlays, with the origin of each entry")
+	if err := configFlags.Parse(flagset.Args()[1:]); err != nil {
+		return err
+	}
+	fn := configFlags.Arg(0)
+	if fn == "" {
+		fn = defaultProcfile
+	}
+	c, err := resolveConfig(flagset, fn)
+	if err != nil {
+		return err
+	}
+	if *resolved {
+		return c.Print(os.Stdout)
+	}
+	seen := make(map[string]bool)
+	for _, e := range c.Entries {
+		for _, pos := range e.Origin {
+			if !seen[pos.File] {
+				seen[pos.File] = true
+				fmt.Println(pos.File)
+			}
+		}
+	}
+	return nil
+}
+
+// env prints the environment a process instance receives, see
+// runner.Runner.Environment.
+func env(flagset *flag.FlagSet) error {
+	proc := flagset.Arg(1)
+	if proc == "" {
+		return errors.New("usage: runner [options] env procType[.instance] [Procfile]")
+	}
+	fn := flagset.Arg(2)
+	if fn == "" {
+		fn = defaultProcfile
+	}
+	s, err := loadRunner(flagset, fn)
+	if err != nil {
+		return err
+	}
+	vars, err := s.Environment(proc)
+	if err != nil {
+		return err
+	}
+	for _, kv := range vars {
+		fmt.Println(kv)
+	}
+	return nil
+}
+
+// loadEnv reads the environment file given with --env, if present.
+func loadEnv(flagset *flag.FlagSet) ([]string, error) {
+	envFN := flagset.Lookup("env").Value.String()
+	if envFN == "" {
+		return nil, nil
+	}
+	fd, err := os.Open(envFN)
+	if err != nil {
+		return nil, nil
+	}
+	baseEnv, err := envfile.Parse(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error reading environment file (%v): %v", envFN, err)
 	}
 	if err := fd.Close(); err != nil {
-		return fmt.Errorf("cannot close spec file reader (procfile): %v", err)
+		return nil, fmt.Errorf("cannot close environment file reader (%v): %v", envFN, err)
+	}
+	return baseEnv, nil
+}
+
+func loadRunner(flagset *flag.FlagSet, fn string) (*runner.Runner, error) {
+	baseEnv, err := loadEnv(flagset)
+	if err != nil {
+		return nil, err
+	}
+	if flagset.Lookup("strict").Value.String() == "true" {
+		problems, err := procfile.Check(fn, baseEnv, overlays(flagset, fn)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range problems {
+			log.Println(p)
+		}
+		if procfile.HasErrors(problems) {
+			return nil, fmt.Errorf("%s has errors, refusing to start", fn)
+		}
+	}
+	c, err := resolveConfig(flagset, fn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse spec file (procfile): %v", err)
+	}
+	c.Env = baseEnv
+	s, err := c.Runner()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse spec file (procfile): %v", err)
 	}
 	if formation := flagset.Lookup("formation").Value.String(); formation != "" {
 		s.Formation = procfile.ParseFormation(formation)
@@ -198,55 +672,40 @@ func mainRunner(ctx context.Context, flagset *flag.FlagSet) error {
 	if skip := flagset.Lookup("skip").Value.String(); skip != "" {
 		s.SkipProcs = strings.Fields(skip)
 	}
-	for _, procName := range s.SkipProcs {
-		s.Formation[procName] = 0
-	}
-	if optional := flagset.Lookup("optional").Value.String(); optional != "" {
-		procNames := strings.Fields(optional)
-		for _, procName := range procNames {
-			s.Formation[procName] = 1
-		}
-	}
-	if only := flagset.Lookup("only").Value.String(); only != "" {
-		procNames := strings.Fields(only)
-		s.Formation = make(map[string]int, len(procNames))
-		for _, procName := range procNames {
-			s.Formation[procName] = 1
-		}
+	err = s.Select(runner.Selection{
+		Profile:  flagset.Lookup("profile").Value.String(),
+		Only:     strings.Fields(flagset.Lookup("only").Value.String()),
+		Skip:     s.SkipProcs,
+		Optional: strings.Fields(flagset.Lookup("optional").Value.String()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot select process types: %v", err)
 	}
-	s.WorkDir = os.ExpandEnv(s.WorkDir)
 	if s.WorkDir == "" {
 		wd, err := os.Getwd()
 		if err != nil {
-			return fmt.Errorf("cannot load current workdir: %v", err)
+			return nil, fmt.Errorf("cannot load current workdir: %v", err)
 		}
 		s.WorkDir = wd
 	}
 	s.WorkDir, err = filepath.Abs(filepath.Clean(s.WorkDir))
 	if err != nil {
-		return fmt.Errorf("cannot find absolute path for workdir: %v", err)
+		return nil, fmt.Errorf("cannot find absolute path for workdir: %v", err)
 	}
 	if _, err := os.Stat(s.WorkDir); err != nil {
-		return fmt.Errorf("cannot find work directory: %w", err)
-	}
-	if envFN := flagset.Lookup("env").Value.String(); envFN != "" {
-		fd, err := os.Open(envFN)
-		if err == nil {
-			baseEnv, err := envfile.Parse(fd)
-			if err != nil {
-				return fmt.Errorf("error reading environment file (%v): %v", envFN, err)
-			}
-			if err := fd.Close(); err != nil {
-				return fmt.Errorf("cannot close environment file reader (%v): %v", envFN, err)
-			}
-			s.BaseEnvironment = baseEnv
-		}
+		return nil, fmt.Errorf("cannot find work directory: %w", err)
 	}
+	s.BaseEnvironment = baseEnv
 	s.ServiceDiscoveryAddr = flagset.Lookup("service-discovery").Value.String()
-	if err := s.Start(ctx); err != nil {
-		return fmt.Errorf("cannot serve: %v", err)
+	s.BuildCache = flagset.Lookup("build-cache").Value.String() == "true"
+	s.EditorURL = flagset.Lookup("editor-url").Value.String()
+	s.LiveReload = flagset.Lookup("livereload").Value.String() == "true"
+	s.BasePort, _ = strconv.Atoi(flagset.Lookup("base-port").Value.String())
+	if s.BasePort == 0 && s.Proxy != nil {
+		s.BasePort = defaultProxyBasePort
 	}
-	return nil
+	s.BuildJobs, _ = strconv.Atoi(flagset.Lookup("build-jobs").Value.String())
+	return s, nil
 }
 
 func logs(flagset *flag.FlagSet) error {
@@ -306,6 +765,139 @@ func logs(flagset *flag.FlagSet) error {
 	}
 }
 
+func ps(flagset *flag.FlagSet) error {
+	psFlags := flag.NewFlagSet("ps", flag.ContinueOnError)
+	showBuilds := psFlags.Bool("builds", false, "list the build history instead of the processes")
+	if err := psFlags.Parse(flagset.Args()[1:]); err != nil {
+		return err
+	}
+	path := "/processes"
+	if *showBuilds {
+		path = "/builds"
+	}
+	u := url.URL{Scheme: "http", Host: flagset.Lookup("service-discovery").Value.String(), Path: path}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("cannot connect to service discovery endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	if *showBuilds {
+		var builds []runner.Build
+		if err := json.NewDecoder(resp.Body).Decode(&builds); err != nil {
+			return fmt.Errorf("cannot decode builds: %v", err)
+		}
+		fmt.Fprintln(w, "ID\tTRIGGER\tSTART\tDURATION\tSTATUS\tSTEPS")
+		for _, b := range builds {
+			var steps []string
+			for _, step := range b.Steps {
+				name := step.Name
+				if step.Attempt > 1 {
+					name += fmt.Sprintf("#%d", step.Attempt)
+				}
+				steps = append(steps, fmt.Sprintf("%s:%s(%s)", name, step.Status, step.Duration.Round(time.Millisecond)))
+			}
+			trigger := b.Trigger
+			if trigger == "" {
+				trigger = "-"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", b.ID, trigger, b.Start.Format(time.TimeOnly), b.Duration().Round(time.Millisecond), b.Status, strings.Join(steps, " "))
+		}
+		return nil
+	}
+	var procs []runner.ProcessState
+	if err := json.NewDecoder(resp.Body).Decode(&procs); err != nil {
+		return fmt.Errorf("cannot decode processes: %v", err)
+	}
+	fmt.Fprintln(w, "NAME\tSTATE\tPID\tPORT\tUPTIME\tRESTARTS")
+	for _, p := range procs {
+		uptime := "-"
+		if p.State == runner.StateRunning {
+			uptime = time.Since(p.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%d\n", p.Name, p.State, p.PID, p.Port, uptime, p.Restarts)
+	}
+	return nil
+}
+
+// wait polls the readiness endpoint until the application, or the given
+// process types, are ready.
+func wait(flagset *flag.FlagSet) error {
+	waitFlags := flag.NewFlagSet("wait", flag.ContinueOnError)
+	timeout := waitFlags.Duration("timeout", 2*time.Minute, "how long to wait for the processes to be ready")
+	if err := waitFlags.Parse(flagset.Args()[1:]); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), haltSignals()...)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	u := url.URL{Scheme: "http", Host: flagset.Lookup("service-discovery").Value.String(), Path: "/healthz"}
+	if procs := waitFlags.Args(); len(procs) > 0 {
+		u.RawQuery = url.Values{"proc": procs}.Encode()
+	}
+	check := func() (runner.Health, error) {
+		var h runner.Health
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return h, fmt.Errorf("cannot create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return h, fmt.Errorf("cannot connect to service discovery endpoint: %v", err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			return h, fmt.Errorf("cannot decode health: %v", err)
+		}
+		return h, nil
+	}
+	var lastStatus string
+	t := time.NewTicker(250 * time.Millisecond)
+	defer t.Stop()
+	for {
+		h, err := check()
+		if err == nil && h.Ready {
+			log.Println("ready")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("not ready after %v, pending: %s", *timeout, lastStatus)
+		}
+		if status := pendingSummary(h, err); status != lastStatus {
+			log.Println("waiting:", status)
+			lastStatus = status
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %v, pending: %s", *timeout, lastStatus)
+		case <-t.C:
+		}
+	}
+}
+
+func pendingSummary(h runner.Health, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	var pending []string
+	if h.Build != runner.BuildDone {
+		build := h.Build
+		if build == "" {
+			build = "not started"
+		}
+		pending = append(pending, "build ("+build+")")
+	}
+	for _, ps := range h.Pending {
+		pending = append(pending, ps.Name+" ("+ps.State+")")
+	}
+	return strings.Join(pending, ", ")
+}
+
 func haltSignals() []os.Signal {
 	return []os.Signal{syscall.SIGINT, syscall.SIGTERM}
 }